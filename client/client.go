@@ -0,0 +1,184 @@
+// Package client is a Go client for the internal API of the AWS Marketplace integration service.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrUnauthorized is returned when the service rejects the API key
+var ErrUnauthorized = errors.New("unauthorized: missing or invalid API key")
+
+// maxErrorBodySize bounds how much of an error response is read
+const maxErrorBodySize = 4 * 1024
+
+// APIError is returned for unsuccessful responses of the service
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("request failed with status %d: %s", e.StatusCode, e.Message)
+}
+
+// ValueType is the type of an entitlement value
+type ValueType string
+
+const (
+	ValueTypeBoolean ValueType = "boolean"
+	ValueTypeDouble  ValueType = "double"
+	ValueTypeInteger ValueType = "integer"
+	ValueTypeString  ValueType = "string"
+)
+
+// Value is an entitlement value. Only the field matching Type is set.
+type Value struct {
+	Type         ValueType `json:"type"`
+	BooleanValue *bool     `json:"boolean_value,omitempty"`
+	DoubleValue  *float64  `json:"double_value,omitempty"`
+	IntegerValue *int64    `json:"integer_value,omitempty"`
+	StringValue  *string   `json:"string_value,omitempty"`
+}
+
+// Bool returns the value of a boolean entitlement and whether the value is a boolean
+func (v *Value) Bool() (bool, bool) {
+	if v == nil || v.Type != ValueTypeBoolean || v.BooleanValue == nil {
+		return false, false
+	}
+	return *v.BooleanValue, true
+}
+
+// Double returns the value of a double entitlement and whether the value is a double
+func (v *Value) Double() (float64, bool) {
+	if v == nil || v.Type != ValueTypeDouble || v.DoubleValue == nil {
+		return 0, false
+	}
+	return *v.DoubleValue, true
+}
+
+// Integer returns the value of an integer entitlement and whether the value is an integer
+func (v *Value) Integer() (int64, bool) {
+	if v == nil || v.Type != ValueTypeInteger || v.IntegerValue == nil {
+		return 0, false
+	}
+	return *v.IntegerValue, true
+}
+
+// String returns the value of a string entitlement and whether the value is a string
+func (v *Value) String() (string, bool) {
+	if v == nil || v.Type != ValueTypeString || v.StringValue == nil {
+		return "", false
+	}
+	return *v.StringValue, true
+}
+
+// EntitlementCheck is the answer to an entitlement check. Entitled is true when the customer
+// has the dimension and it has not expired.
+type EntitlementCheck struct {
+	CustomerIdentifier string     `json:"customer_identifier"`
+	ProductCode        string     `json:"product_code"`
+	Dimension          string     `json:"dimension"`
+	Entitled           bool       `json:"entitled"`
+	Value              *Value     `json:"value"`
+	ExpirationDate     *time.Time `json:"expiration_date,omitempty"`
+	Expired            bool       `json:"expired"`
+	Source             string     `json:"source"`
+	Stale              bool       `json:"stale"`
+	CheckedAt          time.Time  `json:"checked_at"`
+}
+
+// Client calls the internal API of the service
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// Option configures optional features of the Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		if httpClient != nil {
+			c.httpClient = httpClient
+		}
+	}
+}
+
+// New creates a client for the service at baseURL, including any path prefix the service is
+// mounted under, authenticating with the internal API key
+func New(baseURL, apiKey string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// CheckEntitlement asks whether a customer has an entitlement dimension for a product
+func (c *Client) CheckEntitlement(ctx context.Context, customerIdentifier, productCode, dimension string) (*EntitlementCheck, error) {
+	query := url.Values{}
+	query.Set("customer_identifier", customerIdentifier)
+	query.Set("product_code", productCode)
+	query.Set("dimension", dimension)
+
+	var check EntitlementCheck
+	if err := c.get(ctx, "/api/v1/entitlements/check", query, &check); err != nil {
+		return nil, err
+	}
+	return &check, nil
+}
+
+// get performs an authenticated GET request and decodes the JSON response into out
+func (c *Client) get(ctx context.Context, path string, query url.Values, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		return decodeAPIError(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// decodeAPIError builds an APIError from an unsuccessful response
+func decodeAPIError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	var payload struct {
+		Error string `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &payload) == nil && payload.Error != "" {
+		message = payload.Error
+	}
+	return &APIError{StatusCode: resp.StatusCode, Message: message}
+}
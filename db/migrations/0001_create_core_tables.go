@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Snapshots of the core tables as created by version 1
+
+type customerV1 struct {
+	CustomerIdentifier string `gorm:"column:customer_identifier;primaryKey;type:varchar(255)"`
+	AWSAccountID       string `gorm:"column:aws_account_id;not null;type:varchar(255)"`
+	Name               string `gorm:"column:name;type:varchar(255)"`
+	Email              string `gorm:"column:email;type:varchar(255)"`
+	Phone              string `gorm:"column:phone;type:varchar(50)"`
+	JobRole            string `gorm:"column:job_role;type:varchar(100)"`
+	Company            string `gorm:"column:company;type:varchar(255)"`
+	Country            string `gorm:"column:country;type:varchar(100)"`
+}
+
+func (customerV1) TableName() string { return "customers" }
+
+type productV1 struct {
+	ProductCode string `gorm:"column:product_code;primaryKey;type:varchar(255)"`
+	ProductID   string `gorm:"column:product_id;type:varchar(255)"`
+	ProductName string `gorm:"column:product_name;type:varchar(255)"`
+}
+
+func (productV1) TableName() string { return "products" }
+
+type entitlementValueV1 struct {
+	ValueID      int64    `gorm:"column:value_id;primaryKey;autoIncrement"`
+	BooleanValue *bool    `gorm:"column:boolean_value;type:boolean"`
+	DoubleValue  *float64 `gorm:"column:double_value;type:double"`
+	IntegerValue *int64   `gorm:"column:integer_value;type:int"`
+	StringValue  *string  `gorm:"column:string_value;type:varchar(255)"`
+	ValueType    string   `gorm:"column:value_type;type:enum('boolean','double','integer','string')"`
+}
+
+func (entitlementValueV1) TableName() string { return "entitlement_values" }
+
+type entitlementV1 struct {
+	EntitlementID      int64     `gorm:"column:entitlement_id;primaryKey;autoIncrement"`
+	CustomerIdentifier string    `gorm:"column:customer_identifier;not null;type:varchar(255);index:idx_entitlements_lookup,priority:1"`
+	ProductCode        string    `gorm:"column:product_code;not null;type:varchar(255);index:idx_entitlements_lookup,priority:2"`
+	Dimension          string    `gorm:"column:dimension;type:varchar(255);index:idx_entitlements_lookup,priority:3"`
+	ExpirationDate     string    `gorm:"column:expiration_date;type:varchar(255)"`
+	ValueID            int64     `gorm:"column:value_id"`
+	CreatedAt          time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP;index:idx_entitlements_lookup,priority:4"`
+	UpdatedAt          time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+func (entitlementV1) TableName() string { return "entitlements" }
+
+var createCoreTables = Migration{
+	Version: 1,
+	Name:    "create_core_tables",
+	Up: func(tx *gorm.DB) error {
+		return tx.Migrator().CreateTable(&customerV1{}, &productV1{}, &entitlementValueV1{}, &entitlementV1{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&entitlementV1{}, &entitlementValueV1{}, &productV1{}, &customerV1{})
+	},
+}
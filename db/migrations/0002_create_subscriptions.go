@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type subscriptionV2 struct {
+	CustomerIdentifier string    `gorm:"column:customer_identifier;primaryKey;type:varchar(255)"`
+	ProductCode        string    `gorm:"column:product_code;primaryKey;type:varchar(255)"`
+	Status             string    `gorm:"column:status;not null;type:varchar(32)"`
+	CreatedAt          time.Time `gorm:"column:created_at"`
+	UpdatedAt          time.Time `gorm:"column:updated_at"`
+}
+
+func (subscriptionV2) TableName() string { return "subscriptions" }
+
+var createSubscriptions = Migration{
+	Version: 2,
+	Name:    "create_subscriptions",
+	Up: func(tx *gorm.DB) error {
+		return tx.Migrator().CreateTable(&subscriptionV2{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&subscriptionV2{})
+	},
+}
@@ -0,0 +1,80 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type usageRecordV3 struct {
+	UsageRecordID      int64     `gorm:"column:usage_record_id;primaryKey;autoIncrement"`
+	IdempotencyKey     *string   `gorm:"column:idempotency_key;uniqueIndex:idx_usage_records_idempotency_key;type:varchar(255)"`
+	CustomerIdentifier string    `gorm:"column:customer_identifier;not null;type:varchar(255)"`
+	ProductCode        string    `gorm:"column:product_code;not null;type:varchar(255)"`
+	Dimension          string    `gorm:"column:dimension;not null;type:varchar(255)"`
+	Quantity           int64     `gorm:"column:quantity;not null"`
+	UsageTimestamp     time.Time `gorm:"column:usage_timestamp;not null"`
+	Allocations        string    `gorm:"column:allocations;type:text"`
+	UsageBucketID      *int64    `gorm:"column:usage_bucket_id;index:idx_usage_records_usage_bucket_id"`
+	CarriedForward     bool      `gorm:"column:carried_forward;not null;default:false"`
+	CreatedAt          time.Time `gorm:"column:created_at"`
+}
+
+func (usageRecordV3) TableName() string { return "usage_records" }
+
+type usageBucketV3 struct {
+	UsageBucketID      int64      `gorm:"column:usage_bucket_id;primaryKey;autoIncrement"`
+	ProductCode        string     `gorm:"column:product_code;not null;type:varchar(255);uniqueIndex:idx_usage_buckets_key"`
+	CustomerIdentifier string     `gorm:"column:customer_identifier;not null;type:varchar(255);uniqueIndex:idx_usage_buckets_key"`
+	Dimension          string     `gorm:"column:dimension;not null;type:varchar(255);uniqueIndex:idx_usage_buckets_key"`
+	UsageHour          time.Time  `gorm:"column:usage_hour;not null;uniqueIndex:idx_usage_buckets_key"`
+	Quantity           int64      `gorm:"column:quantity;not null"`
+	Status             string     `gorm:"column:status;not null;type:varchar(32);index:idx_usage_buckets_status"`
+	MeteringStatus     string     `gorm:"column:metering_status;type:varchar(64)"`
+	MeteringRecordID   *string    `gorm:"column:metering_record_id;type:varchar(255)"`
+	StatusMessage      string     `gorm:"column:status_message;type:varchar(1024)"`
+	Attempts           int        `gorm:"column:attempts;not null;default:0"`
+	NextAttemptAt      *time.Time `gorm:"column:next_attempt_at"`
+	SubmittedAt        *time.Time `gorm:"column:submitted_at"`
+	CreatedAt          time.Time  `gorm:"column:created_at"`
+	UpdatedAt          time.Time  `gorm:"column:updated_at"`
+}
+
+func (usageBucketV3) TableName() string { return "usage_buckets" }
+
+type usageAllocationV3 struct {
+	UsageAllocationID int64  `gorm:"column:usage_allocation_id;primaryKey;autoIncrement"`
+	UsageBucketID     int64  `gorm:"column:usage_bucket_id;not null;uniqueIndex:idx_usage_allocations_key"`
+	TagsHash          string `gorm:"column:tags_hash;not null;type:varchar(64);uniqueIndex:idx_usage_allocations_key"`
+	Tags              string `gorm:"column:tags;type:text"`
+	Quantity          int64  `gorm:"column:quantity;not null"`
+}
+
+func (usageAllocationV3) TableName() string { return "usage_allocations" }
+
+type usageDeadLetterV3 struct {
+	DeadLetterID       int64     `gorm:"column:dead_letter_id;primaryKey;autoIncrement"`
+	UsageBucketID      int64     `gorm:"column:usage_bucket_id;not null;index:idx_usage_dead_letters_usage_bucket_id"`
+	ProductCode        string    `gorm:"column:product_code;not null;type:varchar(255)"`
+	CustomerIdentifier string    `gorm:"column:customer_identifier;not null;type:varchar(255)"`
+	Dimension          string    `gorm:"column:dimension;not null;type:varchar(255)"`
+	UsageHour          time.Time `gorm:"column:usage_hour;not null"`
+	Quantity           int64     `gorm:"column:quantity;not null"`
+	Reason             string    `gorm:"column:reason;not null;type:varchar(64)"`
+	Message            string    `gorm:"column:message;type:varchar(1024)"`
+	Attempts           int       `gorm:"column:attempts;not null"`
+	CreatedAt          time.Time `gorm:"column:created_at"`
+}
+
+func (usageDeadLetterV3) TableName() string { return "usage_dead_letters" }
+
+var createUsageTables = Migration{
+	Version: 3,
+	Name:    "create_usage_tables",
+	Up: func(tx *gorm.DB) error {
+		return tx.Migrator().CreateTable(&usageRecordV3{}, &usageBucketV3{}, &usageAllocationV3{}, &usageDeadLetterV3{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&usageDeadLetterV3{}, &usageAllocationV3{}, &usageBucketV3{}, &usageRecordV3{})
+	},
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// entitlementValueV4 is entitlementValueV1 without the MySQL-only column types
+type entitlementValueV4 struct {
+	ValueID      int64    `gorm:"column:value_id;primaryKey;autoIncrement"`
+	BooleanValue *bool    `gorm:"column:boolean_value;type:boolean"`
+	DoubleValue  *float64 `gorm:"column:double_value"`
+	IntegerValue *int64   `gorm:"column:integer_value;type:int"`
+	StringValue  *string  `gorm:"column:string_value;type:varchar(255)"`
+	ValueType    string   `gorm:"column:value_type;type:varchar(16)"`
+}
+
+func (entitlementValueV4) TableName() string { return "entitlement_values" }
+
+// portableCoreTables converts the enum column of version 1 to the varchar column used on every
+// dialect. Version 1 only runs on MySQL, so the other dialects create the core tables here,
+// keeping tables that already exist.
+var portableCoreTables = Migration{
+	Version: 4,
+	Name:    "portable_core_tables",
+	Up: func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "mysql" {
+			return tx.Exec("ALTER TABLE entitlement_values MODIFY value_type varchar(16)").Error
+		}
+		for _, table := range []any{&customerV1{}, &productV1{}, &entitlementValueV4{}, &entitlementV1{}} {
+			if tx.Migrator().HasTable(table) {
+				continue
+			}
+			if err := tx.Migrator().CreateTable(table); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Down: func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "mysql" {
+			// The varchar column accepts every value of the enum, so there is nothing to revert
+			return nil
+		}
+		return tx.Migrator().DropTable(&entitlementV1{}, &entitlementValueV4{}, &productV1{}, &customerV1{})
+	},
+}
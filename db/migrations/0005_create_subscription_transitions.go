@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type subscriptionTransitionV5 struct {
+	TransitionID       int64     `gorm:"column:transition_id;primaryKey;autoIncrement"`
+	CustomerIdentifier string    `gorm:"column:customer_identifier;not null;type:varchar(255);index:idx_subscription_transitions_subscription"`
+	ProductCode        string    `gorm:"column:product_code;not null;type:varchar(255);index:idx_subscription_transitions_subscription"`
+	FromStatus         string    `gorm:"column:from_status;type:varchar(32)"`
+	ToStatus           string    `gorm:"column:to_status;not null;type:varchar(32)"`
+	Reason             string    `gorm:"column:reason;type:varchar(64)"`
+	CreatedAt          time.Time `gorm:"column:created_at"`
+}
+
+func (subscriptionTransitionV5) TableName() string { return "subscription_transitions" }
+
+var createSubscriptionTransitions = Migration{
+	Version: 5,
+	Name:    "create_subscription_transitions",
+	Up: func(tx *gorm.DB) error {
+		return tx.Migrator().CreateTable(&subscriptionTransitionV5{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&subscriptionTransitionV5{})
+	},
+}
@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type entitlementV6 struct {
+	Removed bool `gorm:"column:removed;not null;default:false"`
+}
+
+func (entitlementV6) TableName() string { return "entitlements" }
+
+type jobLeaseV6 struct {
+	Name      string    `gorm:"column:name;primaryKey;type:varchar(64)"`
+	Holder    string    `gorm:"column:holder;not null;type:varchar(255)"`
+	ExpiresAt time.Time `gorm:"column:expires_at;not null"`
+	UpdatedAt time.Time `gorm:"column:updated_at"`
+}
+
+func (jobLeaseV6) TableName() string { return "job_leases" }
+
+type entitlementReconciliationV6 struct {
+	ReconciliationID int64     `gorm:"column:reconciliation_id;primaryKey;autoIncrement"`
+	Holder           string    `gorm:"column:holder;not null;type:varchar(255)"`
+	StartedAt        time.Time `gorm:"column:started_at;not null"`
+	FinishedAt       time.Time `gorm:"column:finished_at;not null"`
+	Products         int       `gorm:"column:products;not null"`
+	FailedProducts   int       `gorm:"column:failed_products;not null"`
+	Created          int       `gorm:"column:created;not null"`
+	Changed          int       `gorm:"column:changed;not null"`
+	Removed          int       `gorm:"column:removed;not null"`
+	Unchanged        int       `gorm:"column:unchanged;not null"`
+	Details          string    `gorm:"column:details;type:text"`
+}
+
+func (entitlementReconciliationV6) TableName() string { return "entitlement_reconciliations" }
+
+var entitlementReconciliation = Migration{
+	Version: 6,
+	Name:    "entitlement_reconciliation",
+	Up: func(tx *gorm.DB) error {
+		if err := tx.Migrator().AddColumn(&entitlementV6{}, "Removed"); err != nil {
+			return err
+		}
+		return tx.Migrator().CreateTable(&jobLeaseV6{}, &entitlementReconciliationV6{})
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := tx.Migrator().DropTable(&entitlementReconciliationV6{}, &jobLeaseV6{}); err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn(&entitlementV6{}, "Removed")
+	},
+}
@@ -0,0 +1,146 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// entitlementExpiryV7 holds the timestamp column the string expiration dates are copied into
+type entitlementExpiryV7 struct {
+	EntitlementID      int64      `gorm:"column:entitlement_id;primaryKey"`
+	ExpirationDate     string     `gorm:"column:expiration_date;type:varchar(255)"`
+	ExpirationDateTime *time.Time `gorm:"column:expiration_date_ts"`
+}
+
+func (entitlementExpiryV7) TableName() string { return "entitlements" }
+
+type entitlementV7 struct {
+	ExpirationDate *time.Time `gorm:"column:expiration_date;index:idx_entitlements_expiration_date"`
+}
+
+func (entitlementV7) TableName() string { return "entitlements" }
+
+type customerV7 struct {
+	Active        bool       `gorm:"column:active;not null;default:true"`
+	DeactivatedAt *time.Time `gorm:"column:deactivated_at"`
+}
+
+func (customerV7) TableName() string { return "customers" }
+
+// entitlementExpiryWarningV7 keys warnings on the entitlement dimension rather than on an
+// entitlement version, so that a new version with the same expiration date is not warned about
+// again. window_days is an int so that the key fits the 3072 byte index limit of MySQL.
+type entitlementExpiryWarningV7 struct {
+	WarningID          int64     `gorm:"column:warning_id;primaryKey;autoIncrement"`
+	CustomerIdentifier string    `gorm:"column:customer_identifier;not null;type:varchar(255);uniqueIndex:idx_entitlement_expiry_warnings_dimension"`
+	ProductCode        string    `gorm:"column:product_code;not null;type:varchar(255);uniqueIndex:idx_entitlement_expiry_warnings_dimension"`
+	Dimension          string    `gorm:"column:dimension;not null;type:varchar(255);uniqueIndex:idx_entitlement_expiry_warnings_dimension"`
+	ExpirationDate     time.Time `gorm:"column:expiration_date;not null;uniqueIndex:idx_entitlement_expiry_warnings_dimension"`
+	WindowDays         int       `gorm:"column:window_days;not null;type:int;uniqueIndex:idx_entitlement_expiry_warnings_dimension"`
+	CreatedAt          time.Time `gorm:"column:created_at"`
+}
+
+func (entitlementExpiryWarningV7) TableName() string { return "entitlement_expiry_warnings" }
+
+var entitlementExpiry = Migration{
+	Version: 7,
+	Name:    "entitlement_expiry",
+	Up: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+
+		// Dates are parsed before the schema changes, since MySQL cannot roll back DDL and the
+		// migration has to be run again once unparsable dates are fixed
+		var rows []entitlementExpiryV7
+		if err := tx.Where("expiration_date IS NOT NULL AND expiration_date <> ''").Find(&rows).Error; err != nil {
+			return err
+		}
+		expirations := make(map[int64]time.Time, len(rows))
+		var unparsable []string
+		for _, row := range rows {
+			expiration, err := time.Parse(time.RFC3339, row.ExpirationDate)
+			if err != nil {
+				unparsable = append(unparsable, fmt.Sprintf("%d (%q)", row.EntitlementID, row.ExpirationDate))
+				continue
+			}
+			expirations[row.EntitlementID] = expiration.UTC()
+		}
+		if len(unparsable) > 0 {
+			return fmt.Errorf("entitlements with unparsable expiration dates: %s; fix or clear their expiration_date and run the migration again",
+				strings.Join(unparsable, ", "))
+		}
+
+		if err := m.AddColumn(&entitlementExpiryV7{}, "ExpirationDateTime"); err != nil {
+			return err
+		}
+		for entitlementID, expiration := range expirations {
+			if err := tx.Model(&entitlementExpiryV7{}).
+				Where("entitlement_id = ?", entitlementID).
+				Update("expiration_date_ts", expiration).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := m.DropColumn(&entitlementExpiryV7{}, "expiration_date"); err != nil {
+			return err
+		}
+		// The model passed determines the column type on servers that rename through ALTER TABLE ... CHANGE
+		if err := m.RenameColumn(&entitlementV7{}, "expiration_date_ts", "expiration_date"); err != nil {
+			return err
+		}
+		if err := m.CreateIndex(&entitlementV7{}, "idx_entitlements_expiration_date"); err != nil {
+			return err
+		}
+
+		if err := m.AddColumn(&customerV7{}, "Active"); err != nil {
+			return err
+		}
+		if err := m.AddColumn(&customerV7{}, "DeactivatedAt"); err != nil {
+			return err
+		}
+		return m.CreateTable(&entitlementExpiryWarningV7{})
+	},
+	Down: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		if err := m.DropTable(&entitlementExpiryWarningV7{}); err != nil {
+			return err
+		}
+		if err := m.DropColumn(&customerV7{}, "deactivated_at"); err != nil {
+			return err
+		}
+		if err := m.DropColumn(&customerV7{}, "active"); err != nil {
+			return err
+		}
+
+		if err := m.DropIndex(&entitlementV7{}, "idx_entitlements_expiration_date"); err != nil {
+			return err
+		}
+		if err := m.RenameColumn(&entitlementExpiryV7{}, "expiration_date", "expiration_date_ts"); err != nil {
+			return err
+		}
+		if err := m.AddColumn(&entitlementExpiryV7{}, "ExpirationDate"); err != nil {
+			return err
+		}
+
+		var rows []struct {
+			EntitlementID      int64
+			ExpirationDateTime *time.Time `gorm:"column:expiration_date_ts"`
+		}
+		if err := tx.Table("entitlements").
+			Select("entitlement_id, expiration_date_ts").
+			Where("expiration_date_ts IS NOT NULL").
+			Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := tx.Model(&entitlementExpiryV7{}).
+				Where("entitlement_id = ?", row.EntitlementID).
+				Update("expiration_date", row.ExpirationDateTime.UTC().Format(time.RFC3339)).Error; err != nil {
+				return err
+			}
+		}
+		return m.DropColumn(&entitlementExpiryV7{}, "expiration_date_ts")
+	},
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type webhookDeliveryV8 struct {
+	DeliveryID     int64      `gorm:"column:delivery_id;primaryKey;autoIncrement"`
+	EventID        string     `gorm:"column:event_id;not null;type:varchar(64);index:idx_webhook_deliveries_event_id"`
+	EventType      string     `gorm:"column:event_type;not null;type:varchar(64)"`
+	URL            string     `gorm:"column:url;not null;type:varchar(2048)"`
+	Payload        string     `gorm:"column:payload;not null;type:text"`
+	Status         string     `gorm:"column:status;not null;type:varchar(16);index:idx_webhook_deliveries_status"`
+	Attempts       int        `gorm:"column:attempts;not null;default:0"`
+	NextAttemptAt  *time.Time `gorm:"column:next_attempt_at"`
+	LastStatusCode int        `gorm:"column:last_status_code"`
+	LastError      string     `gorm:"column:last_error;type:varchar(1024)"`
+	DeliveredAt    *time.Time `gorm:"column:delivered_at"`
+	CreatedAt      time.Time  `gorm:"column:created_at"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at"`
+}
+
+func (webhookDeliveryV8) TableName() string { return "webhook_deliveries" }
+
+var createWebhookDeliveries = Migration{
+	Version: 8,
+	Name:    "create_webhook_deliveries",
+	Up: func(tx *gorm.DB) error {
+		return tx.Migrator().CreateTable(&webhookDeliveryV8{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&webhookDeliveryV8{})
+	},
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type outboxEventV9 struct {
+	OutboxID           int64      `gorm:"column:outbox_id;primaryKey;autoIncrement"`
+	EventID            string     `gorm:"column:event_id;not null;type:varchar(64);uniqueIndex:idx_outbox_events_event_id"`
+	EventType          string     `gorm:"column:event_type;not null;type:varchar(64)"`
+	CustomerIdentifier string     `gorm:"column:customer_identifier;not null;type:varchar(255)"`
+	ProductCode        string     `gorm:"column:product_code;type:varchar(255)"`
+	Data               string     `gorm:"column:data;not null;type:text"`
+	Status             string     `gorm:"column:status;not null;type:varchar(16);index:idx_outbox_events_status"`
+	Attempts           int        `gorm:"column:attempts;not null;default:0"`
+	AvailableAt        time.Time  `gorm:"column:available_at;not null"`
+	LastError          string     `gorm:"column:last_error;type:varchar(1024)"`
+	PublishedAt        *time.Time `gorm:"column:published_at"`
+	CreatedAt          time.Time  `gorm:"column:created_at"`
+	UpdatedAt          time.Time  `gorm:"column:updated_at"`
+}
+
+func (outboxEventV9) TableName() string { return "outbox_events" }
+
+var createOutboxEvents = Migration{
+	Version: 9,
+	Name:    "create_outbox_events",
+	Up: func(tx *gorm.DB) error {
+		return tx.Migrator().CreateTable(&outboxEventV9{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&outboxEventV9{})
+	},
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+type productV10 struct {
+	LogoURL        string `gorm:"column:logo_url;type:varchar(2048)"`
+	SupportContact string `gorm:"column:support_contact;type:varchar(255)"`
+	RedirectURL    string `gorm:"column:redirect_url;type:varchar(2048)"`
+}
+
+func (productV10) TableName() string { return "products" }
+
+var productCatalog = Migration{
+	Version: 10,
+	Name:    "product_catalog",
+	Up: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		for _, field := range []string{"LogoURL", "SupportContact", "RedirectURL"} {
+			if err := m.AddColumn(&productV10{}, field); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Down: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		for _, field := range []string{"LogoURL", "SupportContact", "RedirectURL"} {
+			if err := m.DropColumn(&productV10{}, field); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,229 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Migration is a versioned change to the database schema
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+	// Dialects limits the migration to the listed database dialects. On any other dialect it is
+	// recorded as applied without running. Empty means every dialect.
+	Dialects []string
+}
+
+// runsOn reports whether the migration runs on the dialect
+func (m Migration) runsOn(dialect string) bool {
+	return len(m.Dialects) == 0 || slices.Contains(m.Dialects, dialect)
+}
+
+// onlyOn limits migration to the given dialects
+func onlyOn(migration Migration, dialects ...string) Migration {
+	migration.Dialects = dialects
+	return migration
+}
+
+// SchemaMigration represents the schema_migrations table
+type SchemaMigration struct {
+	Version   int64     `gorm:"column:version;primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"column:name;not null;type:varchar(255)"`
+	AppliedAt time.Time `gorm:"column:applied_at;not null"`
+}
+
+// TableName specifies the table name for SchemaMigration
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// all lists every migration of the service. New migrations are appended with the next version.
+var all = []Migration{
+	// Version 1 uses MySQL column types, the other dialects create the core tables in version 4
+	onlyOn(createCoreTables, "mysql"),
+	createSubscriptions,
+	createUsageTables,
+	portableCoreTables,
+	createSubscriptionTransitions,
+	entitlementReconciliation,
+	entitlementExpiry,
+	createWebhookDeliveries,
+	createOutboxEvents,
+	productCatalog,
+}
+
+// Migrator applies and reverts migrations
+type Migrator struct {
+	db         *gorm.DB
+	logger     *zap.SugaredLogger
+	migrations []Migration
+}
+
+// New creates a migrator for all migrations of the service
+func New(db *gorm.DB, logger *zap.SugaredLogger) *Migrator {
+	migrations := append([]Migration(nil), all...)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return &Migrator{
+		db:         db,
+		logger:     logger.Named("migrations"),
+		migrations: migrations,
+	}
+}
+
+// Up applies all pending migrations in order and returns how many were applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		m.logger.Infow("Applying migration", "version", migration.Version, "name", migration.Name)
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if migration.runsOn(tx.Dialector.Name()) {
+				if err := migration.Up(tx); err != nil {
+					return err
+				}
+			}
+			return tx.Create(&SchemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil && migration.Version == createCoreTables.Version && m.db.Migrator().HasTable("customers") {
+			return count, fmt.Errorf("migration %d (%s) failed: %w; the tables already exist, run `migrate baseline` to adopt them", migration.Version, migration.Name, err)
+		}
+		if err != nil {
+			return count, fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Baseline records every migration up to version as applied without running it, so that a
+// database whose tables were created by hand can be brought under migration control
+func (m *Migrator) Baseline(ctx context.Context, version int64) (int, error) {
+	if !slices.ContainsFunc(m.migrations, func(migration Migration) bool { return migration.Version == version }) {
+		return 0, fmt.Errorf("unknown migration version %d", version)
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, migration := range m.migrations {
+		if migration.Version > version {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		m.logger.Infow("Recording migration as applied", "version", migration.Version, "name", migration.Name)
+		if err := m.db.WithContext(ctx).Create(&SchemaMigration{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: time.Now(),
+		}).Error; err != nil {
+			return count, fmt.Errorf("failed to record migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Down reverts the last steps applied migrations, most recent first
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	if steps < 1 {
+		return 0, errors.New("steps must be at least 1")
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		m.logger.Infow("Reverting migration", "version", migration.Version, "name", migration.Name)
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if migration.runsOn(tx.Dialector.Name()) {
+				if err := migration.Down(tx); err != nil {
+					return err
+				}
+			}
+			return tx.Delete(&SchemaMigration{}, "version = ?", migration.Version).Error
+		})
+		if err != nil {
+			return count, fmt.Errorf("reverting migration %d (%s) failed: %w", migration.Version, migration.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Status lists all migrations and when they were applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			appliedAt := record.AppliedAt
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// applied creates the schema_migrations table if needed and returns the applied migrations
+func (m *Migrator) applied(ctx context.Context) (map[int64]SchemaMigration, error) {
+	db := m.db.WithContext(ctx)
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		if err := db.Migrator().CreateTable(&SchemaMigration{}); err != nil {
+			return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+		}
+	}
+
+	var records []SchemaMigration
+	if err := db.Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations table: %w", err)
+	}
+	applied := make(map[int64]SchemaMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
@@ -0,0 +1,136 @@
+package migrations
+
+import (
+	"aws-markertplace-integration/db"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	database, err := db.Open("sqlite://" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+func TestMigratorUpAndDown(t *testing.T) {
+	ctx := context.Background()
+	migrator := New(openTestDB(t), zap.NewNop().Sugar())
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	if applied != len(all) {
+		t.Fatalf("Up() applied %d migrations, want %d", applied, len(all))
+	}
+	if applied, err := migrator.Up(ctx); err != nil || applied != 0 {
+		t.Fatalf("second Up() = %d, %v, want 0, nil", applied, err)
+	}
+
+	reverted, err := migrator.Down(ctx, len(all))
+	if err != nil {
+		t.Fatalf("Down() error = %v", err)
+	}
+	if reverted != len(all) {
+		t.Fatalf("Down() reverted %d migrations, want %d", reverted, len(all))
+	}
+}
+
+func TestMigratorBaseline(t *testing.T) {
+	ctx := context.Background()
+	database := openTestDB(t)
+	migrator := New(database, zap.NewNop().Sugar())
+
+	// Tables created by hand before migrations existed
+	if err := database.Migrator().CreateTable(&customerV1{}, &productV1{}, &entitlementValueV4{}, &entitlementV1{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrator.Baseline(ctx, 999); err == nil {
+		t.Fatal("Baseline() of an unknown version succeeded")
+	}
+	recorded, err := migrator.Baseline(ctx, 1)
+	if err != nil {
+		t.Fatalf("Baseline() error = %v", err)
+	}
+	if recorded != 1 {
+		t.Fatalf("Baseline() recorded %d migrations, want 1", recorded)
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up() after Baseline() error = %v", err)
+	}
+	if applied != len(all)-1 {
+		t.Fatalf("Up() applied %d migrations, want %d", applied, len(all)-1)
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("migration %d (%s) is not applied", status.Version, status.Name)
+		}
+	}
+}
+
+// migrateTo applies every migration and reverts those after version
+func migrateTo(t *testing.T, migrator *Migrator, version int64) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	steps := 0
+	for _, migration := range all {
+		if migration.Version > version {
+			steps++
+		}
+	}
+	if _, err := migrator.Down(ctx, steps); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEntitlementExpiryUnparsableDates(t *testing.T) {
+	ctx := context.Background()
+	database := openTestDB(t)
+	migrator := New(database, zap.NewNop().Sugar())
+	migrateTo(t, migrator, entitlementExpiry.Version-1)
+
+	for _, expiration := range []string{"2030-01-02T03:04:05Z", "next tuesday"} {
+		if err := database.Create(&entitlementV1{CustomerIdentifier: "c1", ProductCode: "p1", Dimension: expiration, ExpirationDate: expiration}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := migrator.Up(ctx)
+	if err == nil || !strings.Contains(err.Error(), `2 ("next tuesday")`) {
+		t.Fatalf("Up() error = %v, want the unparsable entitlement listed", err)
+	}
+
+	if err := database.Model(&entitlementExpiryV7{}).Where("entitlement_id = ?", 2).Update("expiration_date", "").Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up() after fixing the date error = %v", err)
+	}
+	var expiration time.Time
+	if err := database.Table("entitlements").Select("expiration_date").Where("entitlement_id = ?", 1).Scan(&expiration).Error; err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC); !expiration.Equal(want) {
+		t.Fatalf("expiration_date = %v, want %v", expiration, want)
+	}
+}
@@ -16,6 +16,8 @@ type Customer struct {
 	JobRole            string        `gorm:"column:job_role;type:varchar(100)" json:"job_role"`
 	Company            string        `gorm:"column:company;type:varchar(255)" json:"company"`
 	Country            string        `gorm:"column:country;type:varchar(100)" json:"country"`
+	Active             bool          `gorm:"column:active;not null;default:true" json:"active"`
+	DeactivatedAt      *time.Time    `gorm:"column:deactivated_at" json:"deactivated_at,omitempty"`
 	Entitlements       []Entitlement `gorm:"foreignKey:CustomerIdentifier" json:"entitlements,omitempty"`
 }
 
@@ -24,12 +26,15 @@ func (Customer) TableName() string {
 	return "customers"
 }
 
-// Product represents the products table
+// Product represents the products table. The display fields come from the product catalog.
 type Product struct {
-	ProductCode  string        `gorm:"column:product_code;primaryKey;type:varchar(255)" json:"product_code"`
-	ProductID    string        `gorm:"column:product_id;type:varchar(255)" json:"product_id"`
-	ProductName  string        `gorm:"column:product_name;type:varchar(255)" json:"product_name"`
-	Entitlements []Entitlement `gorm:"foreignKey:ProductCode" json:"entitlements,omitempty"`
+	ProductCode    string        `gorm:"column:product_code;primaryKey;type:varchar(255)" json:"product_code"`
+	ProductID      string        `gorm:"column:product_id;type:varchar(255)" json:"product_id"`
+	ProductName    string        `gorm:"column:product_name;type:varchar(255)" json:"product_name"`
+	LogoURL        string        `gorm:"column:logo_url;type:varchar(2048)" json:"logo_url"`
+	SupportContact string        `gorm:"column:support_contact;type:varchar(255)" json:"support_contact"`
+	RedirectURL    string        `gorm:"column:redirect_url;type:varchar(2048)" json:"redirect_url"`
+	Entitlements   []Entitlement `gorm:"foreignKey:ProductCode" json:"entitlements,omitempty"`
 }
 
 // TableName specifies the table name for Product
@@ -51,10 +56,10 @@ const (
 type EntitlementValue struct {
 	ValueID      int64     `gorm:"column:value_id;primaryKey;autoIncrement" json:"value_id"`
 	BooleanValue *bool     `gorm:"column:boolean_value;type:boolean" json:"boolean_value,omitempty"`
-	DoubleValue  *float64  `gorm:"column:double_value;type:double" json:"double_value,omitempty"`
+	DoubleValue  *float64  `gorm:"column:double_value" json:"double_value,omitempty"`
 	IntegerValue *int64    `gorm:"column:integer_value;type:int" json:"integer_value,omitempty"`
 	StringValue  *string   `gorm:"column:string_value;type:varchar(255)" json:"string_value,omitempty"`
-	ValueType    ValueType `gorm:"column:value_type;type:enum('boolean','double','integer','string')" json:"value_type"`
+	ValueType    ValueType `gorm:"column:value_type;type:varchar(16)" json:"value_type"`
 }
 
 // TableName specifies the table name for EntitlementValue
@@ -68,13 +73,14 @@ type Entitlement struct {
 	CustomerIdentifier string            `gorm:"column:customer_identifier;not null;type:varchar(255)" json:"customer_identifier"`
 	ProductCode        string            `gorm:"column:product_code;not null;type:varchar(255)" json:"product_code"`
 	Dimension          string            `gorm:"column:dimension;type:varchar(255)" json:"dimension"`
-	ExpirationDate     string            `gorm:"column:expiration_date;type:varchar(255)" json:"expiration_date"`
+	ExpirationDate     *time.Time        `gorm:"column:expiration_date;index:idx_entitlements_expiration_date" json:"expiration_date,omitempty"`
 	ValueID            int64             `gorm:"column:value_id" json:"value_id"`
+	Removed            bool              `gorm:"column:removed;not null;default:false" json:"removed"`
 	CreatedAt          time.Time         `gorm:"column:created_at;default:CURRENT_TIMESTAMP" json:"created_at"`
 	UpdatedAt          time.Time         `gorm:"column:updated_at;default:CURRENT_TIMESTAMP" json:"updated_at"`
-	Customer           *Customer         `gorm:"foreignKey:CustomerIdentifier" json:"customer,omitempty"`
-	Product            *Product          `gorm:"foreignKey:ProductCode" json:"product,omitempty"`
-	Value              *EntitlementValue `gorm:"foreignKey:ValueID" json:"value,omitempty"`
+	Customer           *Customer         `gorm:"foreignKey:CustomerIdentifier;references:CustomerIdentifier" json:"customer,omitempty"`
+	Product            *Product          `gorm:"foreignKey:ProductCode;references:ProductCode" json:"product,omitempty"`
+	Value              *EntitlementValue `gorm:"foreignKey:ValueID;references:ValueID" json:"value,omitempty"`
 }
 
 // TableName specifies the table name for Entitlement
@@ -95,3 +101,317 @@ func (e *Entitlement) BeforeUpdate(tx *gorm.DB) error {
 	e.UpdatedAt = time.Now()
 	return nil
 }
+
+// SubscriptionStatus represents the possible states of a customer's product subscription
+type SubscriptionStatus string
+
+const (
+	SubscriptionStatusPending             SubscriptionStatus = "pending"
+	SubscriptionStatusActive              SubscriptionStatus = "active"
+	SubscriptionStatusFailed              SubscriptionStatus = "failed"
+	SubscriptionStatusPendingCancellation SubscriptionStatus = "pending_cancellation"
+	SubscriptionStatusCancelled           SubscriptionStatus = "cancelled"
+)
+
+// AllowsAccess reports whether a customer in this state may use the product. Access is kept
+// while a cancellation is pending, since AWS still bills the customer until it completes.
+func (s SubscriptionStatus) AllowsAccess() bool {
+	return s == SubscriptionStatusActive || s == SubscriptionStatusPendingCancellation
+}
+
+// Subscription represents the subscriptions table
+type Subscription struct {
+	CustomerIdentifier string             `gorm:"column:customer_identifier;primaryKey;type:varchar(255)" json:"customer_identifier"`
+	ProductCode        string             `gorm:"column:product_code;primaryKey;type:varchar(255)" json:"product_code"`
+	Status             SubscriptionStatus `gorm:"column:status;not null;type:varchar(32)" json:"status"`
+	CreatedAt          time.Time          `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt          time.Time          `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName specifies the table name for Subscription
+func (Subscription) TableName() string {
+	return "subscriptions"
+}
+
+// SubscriptionTransition represents the subscription_transitions table. FromStatus is empty
+// for the transition that created the subscription.
+type SubscriptionTransition struct {
+	TransitionID       int64              `gorm:"column:transition_id;primaryKey;autoIncrement" json:"transition_id"`
+	CustomerIdentifier string             `gorm:"column:customer_identifier;not null;type:varchar(255);index:idx_subscription_transitions_subscription" json:"customer_identifier"`
+	ProductCode        string             `gorm:"column:product_code;not null;type:varchar(255);index:idx_subscription_transitions_subscription" json:"product_code"`
+	FromStatus         SubscriptionStatus `gorm:"column:from_status;type:varchar(32)" json:"from_status"`
+	ToStatus           SubscriptionStatus `gorm:"column:to_status;not null;type:varchar(32)" json:"to_status"`
+	Reason             string             `gorm:"column:reason;type:varchar(64)" json:"reason"`
+	CreatedAt          time.Time          `gorm:"column:created_at" json:"created_at"`
+}
+
+// TableName specifies the table name for SubscriptionTransition
+func (SubscriptionTransition) TableName() string {
+	return "subscription_transitions"
+}
+
+// UsageRecord represents the usage_records table
+type UsageRecord struct {
+	UsageRecordID      int64                   `gorm:"column:usage_record_id;primaryKey;autoIncrement" json:"usage_record_id"`
+	IdempotencyKey     *string                 `gorm:"column:idempotency_key;uniqueIndex;type:varchar(255)" json:"idempotency_key,omitempty"`
+	CustomerIdentifier string                  `gorm:"column:customer_identifier;not null;type:varchar(255)" json:"customer_identifier"`
+	ProductCode        string                  `gorm:"column:product_code;not null;type:varchar(255)" json:"product_code"`
+	Dimension          string                  `gorm:"column:dimension;not null;type:varchar(255)" json:"dimension"`
+	Quantity           int64                   `gorm:"column:quantity;not null" json:"quantity"`
+	UsageTimestamp     time.Time               `gorm:"column:usage_timestamp;not null" json:"timestamp"`
+	Allocations        []UsageRecordAllocation `gorm:"column:allocations;type:text;serializer:json" json:"allocations,omitempty"`
+	UsageBucketID      *int64                  `gorm:"column:usage_bucket_id;index" json:"usage_bucket_id,omitempty"`
+	CarriedForward     bool                    `gorm:"column:carried_forward;not null;default:false" json:"carried_forward"`
+	CreatedAt          time.Time               `gorm:"column:created_at" json:"created_at"`
+}
+
+// UsageTag represents a key-value tag used for cost allocation
+type UsageTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// UsageRecordAllocation represents the part of a usage record's quantity attributed to a set of tags
+type UsageRecordAllocation struct {
+	Quantity int64      `json:"quantity"`
+	Tags     []UsageTag `json:"tags,omitempty"`
+}
+
+// TableName specifies the table name for UsageRecord
+func (UsageRecord) TableName() string {
+	return "usage_records"
+}
+
+// UsageBucketStatus represents the submission state of an hourly usage bucket
+type UsageBucketStatus string
+
+const (
+	UsageBucketStatusPending    UsageBucketStatus = "pending"
+	UsageBucketStatusSubmitting UsageBucketStatus = "submitting"
+	UsageBucketStatusSubmitted  UsageBucketStatus = "submitted"
+	UsageBucketStatusFailed     UsageBucketStatus = "failed"
+)
+
+// UsageBucket represents the usage_buckets table, holding the usage of a customer
+// for one dimension and hour
+type UsageBucket struct {
+	UsageBucketID      int64             `gorm:"column:usage_bucket_id;primaryKey;autoIncrement" json:"usage_bucket_id"`
+	ProductCode        string            `gorm:"column:product_code;not null;type:varchar(255);uniqueIndex:idx_usage_buckets_key" json:"product_code"`
+	CustomerIdentifier string            `gorm:"column:customer_identifier;not null;type:varchar(255);uniqueIndex:idx_usage_buckets_key" json:"customer_identifier"`
+	Dimension          string            `gorm:"column:dimension;not null;type:varchar(255);uniqueIndex:idx_usage_buckets_key" json:"dimension"`
+	UsageHour          time.Time         `gorm:"column:usage_hour;not null;uniqueIndex:idx_usage_buckets_key" json:"usage_hour"`
+	Quantity           int64             `gorm:"column:quantity;not null" json:"quantity"`
+	Status             UsageBucketStatus `gorm:"column:status;not null;type:varchar(32);index" json:"status"`
+	MeteringStatus     string            `gorm:"column:metering_status;type:varchar(64)" json:"metering_status,omitempty"`
+	MeteringRecordID   *string           `gorm:"column:metering_record_id;type:varchar(255)" json:"metering_record_id,omitempty"`
+	StatusMessage      string            `gorm:"column:status_message;type:varchar(1024)" json:"status_message,omitempty"`
+	Attempts           int               `gorm:"column:attempts;not null;default:0" json:"attempts"`
+	NextAttemptAt      *time.Time        `gorm:"column:next_attempt_at" json:"next_attempt_at,omitempty"`
+	SubmittedAt        *time.Time        `gorm:"column:submitted_at" json:"submitted_at,omitempty"`
+	CreatedAt          time.Time         `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt          time.Time         `gorm:"column:updated_at" json:"updated_at"`
+	Allocations        []UsageAllocation `gorm:"foreignKey:UsageBucketID" json:"allocations,omitempty"`
+}
+
+// TableName specifies the table name for UsageBucket
+func (UsageBucket) TableName() string {
+	return "usage_buckets"
+}
+
+// UsageAllocation represents the usage_allocations table, holding the quantity of a usage
+// bucket attributed to one set of tags
+type UsageAllocation struct {
+	UsageAllocationID int64      `gorm:"column:usage_allocation_id;primaryKey;autoIncrement" json:"usage_allocation_id"`
+	UsageBucketID     int64      `gorm:"column:usage_bucket_id;not null;uniqueIndex:idx_usage_allocations_key" json:"usage_bucket_id"`
+	TagsHash          string     `gorm:"column:tags_hash;not null;type:varchar(64);uniqueIndex:idx_usage_allocations_key" json:"-"`
+	Tags              []UsageTag `gorm:"column:tags;type:text;serializer:json" json:"tags,omitempty"`
+	Quantity          int64      `gorm:"column:quantity;not null" json:"quantity"`
+}
+
+// TableName specifies the table name for UsageAllocation
+func (UsageAllocation) TableName() string {
+	return "usage_allocations"
+}
+
+// UsageDeadLetter represents the usage_dead_letters table, holding usage buckets that
+// AWS rejected permanently
+type UsageDeadLetter struct {
+	DeadLetterID       int64     `gorm:"column:dead_letter_id;primaryKey;autoIncrement" json:"dead_letter_id"`
+	UsageBucketID      int64     `gorm:"column:usage_bucket_id;not null;index" json:"usage_bucket_id"`
+	ProductCode        string    `gorm:"column:product_code;not null;type:varchar(255)" json:"product_code"`
+	CustomerIdentifier string    `gorm:"column:customer_identifier;not null;type:varchar(255)" json:"customer_identifier"`
+	Dimension          string    `gorm:"column:dimension;not null;type:varchar(255)" json:"dimension"`
+	UsageHour          time.Time `gorm:"column:usage_hour;not null" json:"usage_hour"`
+	Quantity           int64     `gorm:"column:quantity;not null" json:"quantity"`
+	Reason             string    `gorm:"column:reason;not null;type:varchar(64)" json:"reason"`
+	Message            string    `gorm:"column:message;type:varchar(1024)" json:"message,omitempty"`
+	Attempts           int       `gorm:"column:attempts;not null" json:"attempts"`
+	CreatedAt          time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+// TableName specifies the table name for UsageDeadLetter
+func (UsageDeadLetter) TableName() string {
+	return "usage_dead_letters"
+}
+
+// JobLease represents the job_leases table. A lease lets a single replica run a background job.
+type JobLease struct {
+	Name      string    `gorm:"column:name;primaryKey;type:varchar(64)" json:"name"`
+	Holder    string    `gorm:"column:holder;not null;type:varchar(255)" json:"holder"`
+	ExpiresAt time.Time `gorm:"column:expires_at;not null" json:"expires_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName specifies the table name for JobLease
+func (JobLease) TableName() string {
+	return "job_leases"
+}
+
+// EntitlementReconciliation represents the entitlement_reconciliations table
+type EntitlementReconciliation struct {
+	ReconciliationID int64                   `gorm:"column:reconciliation_id;primaryKey;autoIncrement" json:"reconciliation_id"`
+	Holder           string                  `gorm:"column:holder;not null;type:varchar(255)" json:"holder"`
+	StartedAt        time.Time               `gorm:"column:started_at;not null" json:"started_at"`
+	FinishedAt       time.Time               `gorm:"column:finished_at;not null" json:"finished_at"`
+	Products         int                     `gorm:"column:products;not null" json:"products"`
+	FailedProducts   int                     `gorm:"column:failed_products;not null" json:"failed_products"`
+	Created          int                     `gorm:"column:created;not null" json:"created"`
+	Changed          int                     `gorm:"column:changed;not null" json:"changed"`
+	Removed          int                     `gorm:"column:removed;not null" json:"removed"`
+	Unchanged        int                     `gorm:"column:unchanged;not null" json:"unchanged"`
+	Details          []ProductReconciliation `gorm:"column:details;type:text;serializer:json" json:"details"`
+}
+
+// TableName specifies the table name for EntitlementReconciliation
+func (EntitlementReconciliation) TableName() string {
+	return "entitlement_reconciliations"
+}
+
+// ProductReconciliation holds the outcome of reconciling the entitlements of a single product
+type ProductReconciliation struct {
+	ProductCode  string `json:"product_code"`
+	Entitlements int    `json:"entitlements"`
+	Created      int    `json:"created"`
+	Changed      int    `json:"changed"`
+	Removed      int    `json:"removed"`
+	Unchanged    int    `json:"unchanged"`
+	Error        string `json:"error,omitempty"`
+}
+
+// EntitlementExpiryWarning represents the entitlement_expiry_warnings table. A row records that
+// the warning for a window was sent for an entitlement dimension and its expiration date.
+type EntitlementExpiryWarning struct {
+	WarningID          int64     `gorm:"column:warning_id;primaryKey;autoIncrement" json:"warning_id"`
+	CustomerIdentifier string    `gorm:"column:customer_identifier;not null;type:varchar(255);uniqueIndex:idx_entitlement_expiry_warnings_dimension" json:"customer_identifier"`
+	ProductCode        string    `gorm:"column:product_code;not null;type:varchar(255);uniqueIndex:idx_entitlement_expiry_warnings_dimension" json:"product_code"`
+	Dimension          string    `gorm:"column:dimension;not null;type:varchar(255);uniqueIndex:idx_entitlement_expiry_warnings_dimension" json:"dimension"`
+	ExpirationDate     time.Time `gorm:"column:expiration_date;not null;uniqueIndex:idx_entitlement_expiry_warnings_dimension" json:"expiration_date"`
+	WindowDays         int       `gorm:"column:window_days;not null;type:int;uniqueIndex:idx_entitlement_expiry_warnings_dimension" json:"window_days"`
+	CreatedAt          time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+// TableName specifies the table name for EntitlementExpiryWarning
+func (EntitlementExpiryWarning) TableName() string {
+	return "entitlement_expiry_warnings"
+}
+
+// WebhookDeliveryStatus represents the states of an outbound webhook delivery
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryStatusPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliveryStatusSending   WebhookDeliveryStatus = "sending"
+	WebhookDeliveryStatusDelivered WebhookDeliveryStatus = "delivered"
+	WebhookDeliveryStatusFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery represents the webhook_deliveries table, the delivery log of outbound webhooks
+type WebhookDelivery struct {
+	DeliveryID     int64                 `gorm:"column:delivery_id;primaryKey;autoIncrement" json:"delivery_id"`
+	EventID        string                `gorm:"column:event_id;not null;type:varchar(64);index:idx_webhook_deliveries_event_id" json:"event_id"`
+	EventType      string                `gorm:"column:event_type;not null;type:varchar(64)" json:"event_type"`
+	URL            string                `gorm:"column:url;not null;type:varchar(2048)" json:"url"`
+	Payload        string                `gorm:"column:payload;not null;type:text" json:"payload"`
+	Status         WebhookDeliveryStatus `gorm:"column:status;not null;type:varchar(16);index:idx_webhook_deliveries_status" json:"status"`
+	Attempts       int                   `gorm:"column:attempts;not null;default:0" json:"attempts"`
+	NextAttemptAt  *time.Time            `gorm:"column:next_attempt_at" json:"next_attempt_at,omitempty"`
+	LastStatusCode int                   `gorm:"column:last_status_code" json:"last_status_code,omitempty"`
+	LastError      string                `gorm:"column:last_error;type:varchar(1024)" json:"last_error,omitempty"`
+	DeliveredAt    *time.Time            `gorm:"column:delivered_at" json:"delivered_at,omitempty"`
+	CreatedAt      time.Time             `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt      time.Time             `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName specifies the table name for WebhookDelivery
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// Domain event types written to the outbox by the repository
+const (
+	EventTypeCustomerCreated       = "customer.created"
+	EventTypeCustomerRegistered    = "customer.registered"
+	EventTypeEntitlementChanged    = "entitlement.changed"
+	EventTypeSubscriptionCancelled = "subscription.cancelled"
+)
+
+// CustomerCreatedEventData is the data of a customer.created event
+type CustomerCreatedEventData struct {
+	AWSAccountID string `json:"aws_account_id"`
+}
+
+// CustomerRegisteredEventData is the data of a customer.registered event
+type CustomerRegisteredEventData struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Phone   string `json:"phone"`
+	JobRole string `json:"job_role"`
+	Company string `json:"company"`
+	Country string `json:"country"`
+}
+
+// SubscriptionCancelledEventData is the data of a subscription.cancelled event
+type SubscriptionCancelledEventData struct {
+	Action string `json:"action"`
+}
+
+// EntitlementChangedEventData is the data of an entitlement.changed event. It lists the
+// dimensions that were added, changed or removed.
+type EntitlementChangedEventData struct {
+	Created []string `json:"created,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// OutboxEventStatus represents the states of an outbox event
+type OutboxEventStatus string
+
+const (
+	OutboxEventStatusPending    OutboxEventStatus = "pending"
+	OutboxEventStatusPublishing OutboxEventStatus = "publishing"
+	OutboxEventStatusPublished  OutboxEventStatus = "published"
+	OutboxEventStatusFailed     OutboxEventStatus = "failed"
+)
+
+// OutboxEvent represents the outbox_events table. Events are written in the same transaction
+// as the change they describe and published afterwards, so that a crash cannot lose them.
+type OutboxEvent struct {
+	OutboxID           int64             `gorm:"column:outbox_id;primaryKey;autoIncrement" json:"outbox_id"`
+	EventID            string            `gorm:"column:event_id;not null;type:varchar(64);uniqueIndex:idx_outbox_events_event_id" json:"event_id"`
+	EventType          string            `gorm:"column:event_type;not null;type:varchar(64)" json:"event_type"`
+	CustomerIdentifier string            `gorm:"column:customer_identifier;not null;type:varchar(255)" json:"customer_identifier"`
+	ProductCode        string            `gorm:"column:product_code;type:varchar(255)" json:"product_code,omitempty"`
+	Data               string            `gorm:"column:data;not null;type:text" json:"data"`
+	Status             OutboxEventStatus `gorm:"column:status;not null;type:varchar(16);index:idx_outbox_events_status" json:"status"`
+	Attempts           int               `gorm:"column:attempts;not null;default:0" json:"attempts"`
+	AvailableAt        time.Time         `gorm:"column:available_at;not null" json:"available_at"`
+	LastError          string            `gorm:"column:last_error;type:varchar(1024)" json:"last_error,omitempty"`
+	PublishedAt        *time.Time        `gorm:"column:published_at" json:"published_at,omitempty"`
+	CreatedAt          time.Time         `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt          time.Time         `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName specifies the table name for OutboxEvent
+func (OutboxEvent) TableName() string {
+	return "outbox_events"
+}
@@ -0,0 +1,146 @@
+package repo
+
+import (
+	"aws-markertplace-integration/db/models"
+	"context"
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CustomerFilter narrows a customer search. Empty fields do not filter.
+type CustomerFilter struct {
+	// Query matches part of the customer identifier, AWS account, name, email or company
+	Query string
+	// ProductCode and Status match customers with a subscription to the product or in the state
+	ProductCode string
+	Status      models.SubscriptionStatus
+	// Country matches exactly, Company matches part of the company name, both ignoring case
+	Country string
+	Company string
+	Limit   int
+	Offset  int
+}
+
+// ProductUpdate holds the product metadata to change. Nil fields are left as they are.
+type ProductUpdate struct {
+	ProductID      *string
+	ProductName    *string
+	LogoURL        *string
+	SupportContact *string
+	RedirectURL    *string
+}
+
+// SearchCustomers returns the customers matching the filter, ordered by identifier
+func (r *repository) SearchCustomers(ctx context.Context, filter CustomerFilter) ([]models.Customer, error) {
+	query := r.db.WithContext(ctx).Model(&models.Customer{})
+
+	if q := strings.ToLower(strings.TrimSpace(filter.Query)); q != "" {
+		pattern := "%" + q + "%"
+		query = query.Where("LOWER(customer_identifier) LIKE ? OR LOWER(aws_account_id) LIKE ? OR LOWER(name) LIKE ? OR LOWER(email) LIKE ? OR LOWER(company) LIKE ?",
+			pattern, pattern, pattern, pattern, pattern)
+	}
+	if filter.ProductCode != "" || filter.Status != "" {
+		subscribed := r.db.Model(&models.Subscription{}).Select("customer_identifier")
+		if filter.ProductCode != "" {
+			subscribed = subscribed.Where("product_code = ?", filter.ProductCode)
+		}
+		if filter.Status != "" {
+			subscribed = subscribed.Where("status = ?", filter.Status)
+		}
+		query = query.Where("customer_identifier IN (?)", subscribed)
+	}
+	if filter.Country != "" {
+		query = query.Where("LOWER(country) = ?", strings.ToLower(filter.Country))
+	}
+	if filter.Company != "" {
+		query = query.Where("LOWER(company) LIKE ?", "%"+strings.ToLower(filter.Company)+"%")
+	}
+
+	var customers []models.Customer
+	err := query.Order("customer_identifier").
+		Limit(filter.Limit).
+		Offset(filter.Offset).
+		Find(&customers).Error
+	return customers, err
+}
+
+// ListSubscriptionsByCustomerID returns the subscriptions of a customer, ordered by product
+func (r *repository) ListSubscriptionsByCustomerID(ctx context.Context, customerID string) ([]models.Subscription, error) {
+	var subscriptions []models.Subscription
+	err := r.db.WithContext(ctx).
+		Where("customer_identifier = ?", customerID).
+		Order("product_code").
+		Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+// ListProducts returns all known products
+func (r *repository) ListProducts(ctx context.Context) ([]models.Product, error) {
+	var products []models.Product
+	err := r.db.WithContext(ctx).Order("product_code").Find(&products).Error
+	return products, err
+}
+
+// GetProduct returns a product by its code
+func (r *repository) GetProduct(ctx context.Context, productCode string) (*models.Product, error) {
+	var product models.Product
+	if err := r.db.WithContext(ctx).Take(&product, "product_code = ?", productCode).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProductNotFound
+		}
+		return nil, err
+	}
+	return &product, nil
+}
+
+// UpsertProducts creates products or replaces their catalog details. The product id is left as it is.
+func (r *repository) UpsertProducts(ctx context.Context, products []models.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "product_code"}},
+		DoUpdates: clause.AssignmentColumns([]string{"product_name", "logo_url", "support_contact", "redirect_url"}),
+	}).Select("product_code", "product_name", "logo_url", "support_contact", "redirect_url").Create(&products).Error
+}
+
+// UpdateProduct changes the metadata of a product and returns the updated product
+func (r *repository) UpdateProduct(ctx context.Context, productCode string, update ProductUpdate) (*models.Product, error) {
+	var product models.Product
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Take(&product, "product_code = ?", productCode).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrProductNotFound
+			}
+			return err
+		}
+
+		updates := map[string]any{}
+		if update.ProductID != nil {
+			updates["product_id"] = *update.ProductID
+		}
+		if update.ProductName != nil {
+			updates["product_name"] = *update.ProductName
+		}
+		if update.LogoURL != nil {
+			updates["logo_url"] = *update.LogoURL
+		}
+		if update.SupportContact != nil {
+			updates["support_contact"] = *update.SupportContact
+		}
+		if update.RedirectURL != nil {
+			updates["redirect_url"] = *update.RedirectURL
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Model(&product).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
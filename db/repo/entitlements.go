@@ -0,0 +1,134 @@
+package repo
+
+import (
+	"aws-markertplace-integration/db/models"
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// EntitlementFilter narrows entitlement queries to a product or dimension. Empty fields match everything.
+type EntitlementFilter struct {
+	ProductCode string
+	Dimension   string
+	// IncludeRemoved makes current entitlement queries return dimensions whose latest version marks them as removed
+	IncludeRemoved bool
+}
+
+// apply adds the filter conditions to an entitlements query
+func (f EntitlementFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.ProductCode != "" {
+		query = query.Where("product_code = ?", f.ProductCode)
+	}
+	if f.Dimension != "" {
+		query = query.Where("dimension = ?", f.Dimension)
+	}
+	return query
+}
+
+// EntitlementScope identifies the persisted entitlements an entitlement response is complete
+// for. An empty CustomerIdentifier covers every customer of the product.
+type EntitlementScope struct {
+	ProductCode        string
+	CustomerIdentifier string
+}
+
+// contains reports whether a dimension belongs to the scope
+func (s EntitlementScope) contains(key EntitlementDimension) bool {
+	return key.ProductCode == s.ProductCode &&
+		(s.CustomerIdentifier == "" || key.CustomerIdentifier == s.CustomerIdentifier)
+}
+
+// EntitlementDimension identifies an entitlement dimension of a customer
+type EntitlementDimension struct {
+	CustomerIdentifier string `json:"customer_identifier"`
+	ProductCode        string `json:"product_code"`
+	Dimension          string `json:"dimension"`
+}
+
+// less orders dimensions by customer, product and dimension
+func (d EntitlementDimension) less(other EntitlementDimension) bool {
+	if d.CustomerIdentifier != other.CustomerIdentifier {
+		return d.CustomerIdentifier < other.CustomerIdentifier
+	}
+	if d.ProductCode != other.ProductCode {
+		return d.ProductCode < other.ProductCode
+	}
+	return d.Dimension < other.Dimension
+}
+
+// EntitlementChanges reports the versions recorded by UpdateEntitlements
+type EntitlementChanges struct {
+	Created   []EntitlementDimension `json:"created"`
+	Changed   []EntitlementDimension `json:"changed"`
+	Removed   []EntitlementDimension `json:"removed"`
+	Unchanged int                    `json:"unchanged"`
+}
+
+// latestEntitlementVersions loads the latest version of every dimension in scope, removed ones included
+func latestEntitlementVersions(tx *gorm.DB, scope EntitlementScope) (map[EntitlementDimension]models.Entitlement, error) {
+	latest := tx.Model(&models.Entitlement{}).
+		Select("MAX(entitlement_id)").
+		Where("product_code = ?", scope.ProductCode)
+	if scope.CustomerIdentifier != "" {
+		latest = latest.Where("customer_identifier = ?", scope.CustomerIdentifier)
+	}
+	latest = latest.Group("customer_identifier, product_code, dimension")
+
+	var versions []models.Entitlement
+	if err := tx.Preload("Value").Where("entitlement_id IN (?)", latest).Find(&versions).Error; err != nil {
+		return nil, err
+	}
+
+	byDimension := make(map[EntitlementDimension]models.Entitlement, len(versions))
+	for _, version := range versions {
+		byDimension[EntitlementDimension{
+			CustomerIdentifier: version.CustomerIdentifier,
+			ProductCode:        version.ProductCode,
+			Dimension:          version.Dimension,
+		}] = version
+	}
+	return byDimension, nil
+}
+
+// ListCurrentEntitlements returns the latest version of every entitlement dimension of a customer
+func (r *repository) ListCurrentEntitlements(ctx context.Context, customerIdentifier string, filter EntitlementFilter) ([]models.Entitlement, error) {
+	db := r.db.WithContext(ctx)
+
+	// Versions are only ever appended, so the highest id of a dimension is its current version
+	latest := filter.apply(db.Model(&models.Entitlement{}).
+		Select("MAX(entitlement_id)").
+		Where("customer_identifier = ?", customerIdentifier)).
+		Group("product_code, dimension")
+
+	query := db.Preload("Value").Where("entitlement_id IN (?)", latest)
+	if !filter.IncludeRemoved {
+		query = query.Where("removed = ?", false)
+	}
+
+	var entitlements []models.Entitlement
+	err := query.Order("product_code, dimension").Find(&entitlements).Error
+	return entitlements, err
+}
+
+// ListEntitlementHistory returns every version of the entitlements of a customer, grouped by
+// product and dimension and oldest first within each dimension
+func (r *repository) ListEntitlementHistory(ctx context.Context, customerIdentifier string, filter EntitlementFilter) ([]models.Entitlement, error) {
+	var entitlements []models.Entitlement
+	err := filter.apply(r.db.WithContext(ctx).
+		Preload("Value").
+		Where("customer_identifier = ?", customerIdentifier)).
+		Order("product_code, dimension, entitlement_id").
+		Find(&entitlements).Error
+	return entitlements, err
+}
+
+// ListProductCodes returns the codes of all known products
+func (r *repository) ListProductCodes(ctx context.Context) ([]string, error) {
+	var productCodes []string
+	err := r.db.WithContext(ctx).
+		Model(&models.Product{}).
+		Order("product_code").
+		Pluck("product_code", &productCodes).Error
+	return productCodes, err
+}
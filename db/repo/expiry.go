@@ -0,0 +1,109 @@
+package repo
+
+import (
+	"aws-markertplace-integration/db/models"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// currentEntitlementIDs selects the id of the latest version of every entitlement dimension
+func currentEntitlementIDs(db *gorm.DB) *gorm.DB {
+	return db.Model(&models.Entitlement{}).
+		Select("MAX(entitlement_id)").
+		Group("customer_identifier, product_code, dimension")
+}
+
+// ListExpiringEntitlements returns the current entitlements that expire after from and no later than until
+func (r *repository) ListExpiringEntitlements(ctx context.Context, from, until time.Time) ([]models.Entitlement, error) {
+	db := r.db.WithContext(ctx)
+	var entitlements []models.Entitlement
+	err := db.Preload("Value").
+		Where("entitlement_id IN (?)", currentEntitlementIDs(db)).
+		Where("removed = ? AND expiration_date > ? AND expiration_date <= ?", false, from, until).
+		Order("expiration_date, entitlement_id").
+		Find(&entitlements).Error
+	return entitlements, err
+}
+
+// ListEntitlementExpiryWarnings returns the warnings sent for expiration dates after expiringAfter
+func (r *repository) ListEntitlementExpiryWarnings(ctx context.Context, expiringAfter time.Time) ([]models.EntitlementExpiryWarning, error) {
+	var warnings []models.EntitlementExpiryWarning
+	err := r.db.WithContext(ctx).
+		Where("expiration_date > ?", expiringAfter).
+		Find(&warnings).Error
+	return warnings, err
+}
+
+// ClaimEntitlementExpiryWarning records a warning before it is sent. It reports false when the
+// warning was already claimed, so that each warning is sent once across replicas.
+func (r *repository) ClaimEntitlementExpiryWarning(ctx context.Context, warning *models.EntitlementExpiryWarning) (bool, error) {
+	warning.CreatedAt = time.Now()
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(warning)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ReleaseEntitlementExpiryWarning removes a claimed warning that could not be sent, so that it is retried
+func (r *repository) ReleaseEntitlementExpiryWarning(ctx context.Context, warningID int64) error {
+	return r.db.WithContext(ctx).Delete(&models.EntitlementExpiryWarning{}, warningID).Error
+}
+
+// RefreshCustomerActivity marks customers whose current entitlements have all expired as
+// inactive, and customers holding an unexpired entitlement again as active. It returns the
+// customers whose state changed.
+func (r *repository) RefreshCustomerActivity(ctx context.Context, now time.Time) (deactivated, reactivated []string, err error) {
+	db := r.db.WithContext(ctx)
+	entitled := db.Model(&models.Entitlement{}).
+		Select("customer_identifier").
+		Where("entitlement_id IN (?)", currentEntitlementIDs(db)).
+		Where("removed = ?", false)
+	unexpired := entitled.Session(&gorm.Session{}).
+		Where("expiration_date IS NULL OR expiration_date > ?", now)
+
+	var expired []string
+	if err := db.Model(&models.Customer{}).
+		Where("active = ?", true).
+		Where("customer_identifier IN (?)", entitled).
+		Where("customer_identifier NOT IN (?)", unexpired).
+		Pluck("customer_identifier", &expired).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var renewed []string
+	if err := db.Model(&models.Customer{}).
+		Where("active = ?", false).
+		Where("customer_identifier IN (?)", unexpired).
+		Pluck("customer_identifier", &renewed).Error; err != nil {
+		return nil, nil, err
+	}
+
+	// Each customer is updated conditionally, so that concurrent runs report a change only once
+	for _, customerIdentifier := range expired {
+		result := db.Model(&models.Customer{}).
+			Where("customer_identifier = ? AND active = ?", customerIdentifier, true).
+			Updates(map[string]interface{}{"active": false, "deactivated_at": now})
+		if result.Error != nil {
+			return deactivated, reactivated, result.Error
+		}
+		if result.RowsAffected == 1 {
+			deactivated = append(deactivated, customerIdentifier)
+		}
+	}
+	for _, customerIdentifier := range renewed {
+		result := db.Model(&models.Customer{}).
+			Where("customer_identifier = ? AND active = ?", customerIdentifier, false).
+			Updates(map[string]interface{}{"active": true, "deactivated_at": nil})
+		if result.Error != nil {
+			return deactivated, reactivated, result.Error
+		}
+		if result.RowsAffected == 1 {
+			reactivated = append(reactivated, customerIdentifier)
+		}
+	}
+	return deactivated, reactivated, nil
+}
@@ -0,0 +1,137 @@
+package repo
+
+import (
+	"aws-markertplace-integration/db/models"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OutboxEventResult is the outcome of publishing an outbox event. Pending events are retried at RetryAt.
+type OutboxEventResult struct {
+	OutboxID int64
+	Status   models.OutboxEventStatus
+	Error    string
+	RetryAt  time.Time
+}
+
+// writeOutboxEvent stores a domain event in the outbox as part of the transaction tx
+func writeOutboxEvent(tx *gorm.DB, eventType, customerIdentifier, productCode string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	now := time.Now()
+	return tx.Create(&models.OutboxEvent{
+		EventID:            hex.EncodeToString(id),
+		EventType:          eventType,
+		CustomerIdentifier: customerIdentifier,
+		ProductCode:        productCode,
+		Data:               string(payload),
+		Status:             models.OutboxEventStatusPending,
+		AvailableAt:        now,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}).Error
+}
+
+// writeEntitlementChangedEvents stores an entitlement.changed event for every customer product
+// whose entitlements were added, changed or removed
+func writeEntitlementChangedEvents(tx *gorm.DB, changes *EntitlementChanges) error {
+	type customerProduct struct {
+		customerIdentifier string
+		productCode        string
+	}
+	var order []customerProduct
+	data := make(map[customerProduct]*models.EntitlementChangedEventData)
+	add := func(dimensions []EntitlementDimension, field func(*models.EntitlementChangedEventData) *[]string) {
+		for _, d := range dimensions {
+			key := customerProduct{d.CustomerIdentifier, d.ProductCode}
+			if data[key] == nil {
+				data[key] = &models.EntitlementChangedEventData{}
+				order = append(order, key)
+			}
+			list := field(data[key])
+			*list = append(*list, d.Dimension)
+		}
+	}
+	add(changes.Created, func(d *models.EntitlementChangedEventData) *[]string { return &d.Created })
+	add(changes.Changed, func(d *models.EntitlementChangedEventData) *[]string { return &d.Changed })
+	add(changes.Removed, func(d *models.EntitlementChangedEventData) *[]string { return &d.Removed })
+
+	for _, key := range order {
+		if err := writeOutboxEvent(tx, models.EventTypeEntitlementChanged, key.customerIdentifier, key.productCode, data[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClaimOutboxEvents marks pending events that are due as publishing and returns them, oldest
+// first. Events left in publishing by an interrupted run are claimed again.
+func (r *repository) ClaimOutboxEvents(ctx context.Context, limit int) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("(status = ? AND available_at <= ?) OR (status = ? AND updated_at < ?)",
+				models.OutboxEventStatusPending,
+				now,
+				models.OutboxEventStatusPublishing,
+				now.Add(-staleSubmissionTimeout)).
+			Order("outbox_id").
+			Limit(limit).
+			Find(&events).Error; err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, 0, len(events))
+		for i := range events {
+			ids = append(ids, events[i].OutboxID)
+			events[i].Status = models.OutboxEventStatusPublishing
+		}
+		return tx.Model(&models.OutboxEvent{}).
+			Where("outbox_id IN ?", ids).
+			Updates(map[string]any{
+				"status":     models.OutboxEventStatusPublishing,
+				"updated_at": now,
+			}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// UpdateOutboxEventResult marks an event as published or failed, or schedules it for another attempt
+func (r *repository) UpdateOutboxEventResult(ctx context.Context, result OutboxEventResult) error {
+	now := time.Now()
+	updates := map[string]any{
+		"status":     result.Status,
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": truncateMessage(result.Error),
+		"updated_at": now,
+	}
+	switch result.Status {
+	case models.OutboxEventStatusPublished:
+		updates["published_at"] = now
+	case models.OutboxEventStatusPending:
+		updates["available_at"] = result.RetryAt
+	}
+	return r.db.WithContext(ctx).
+		Model(&models.OutboxEvent{}).
+		Where("outbox_id = ?", result.OutboxID).
+		Updates(updates).Error
+}
@@ -0,0 +1,73 @@
+package repo
+
+import (
+	"aws-markertplace-integration/db/models"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacemetering"
+)
+
+func TestOutboxEvents(t *testing.T) {
+	ctx := context.Background()
+	repository, _ := newTestRepository(t)
+
+	if err := repository.UpdateCustomerAdditionalInfo(ctx, "c1", "p1", CustomerAdditionalInfo{Name: "Jane"}); !errors.Is(err, ErrCustomerNotFound) {
+		t.Fatalf("UpdateCustomerAdditionalInfo() of an unknown customer error = %v, want %v", err, ErrCustomerNotFound)
+	}
+	if err := repository.UpdateCustomerBasicInfo(ctx, &marketplacemetering.ResolveCustomerOutput{
+		CustomerIdentifier:   aws.String("c1"),
+		CustomerAWSAccountId: aws.String("111111111111"),
+		ProductCode:          aws.String("p1"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.UpdateCustomerAdditionalInfo(ctx, "c1", "p1", CustomerAdditionalInfo{Name: "Jane", Email: "jane@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range []models.SubscriptionStatus{models.SubscriptionStatusActive, models.SubscriptionStatusCancelled, models.SubscriptionStatusCancelled} {
+		if _, err := repository.TransitionSubscription(ctx, "c1", "p1", status, "unsubscribe-success"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := repository.ClaimOutboxEvents(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTypes := []string{models.EventTypeCustomerCreated, models.EventTypeCustomerRegistered, models.EventTypeSubscriptionCancelled}
+	if len(events) != len(wantTypes) {
+		t.Fatalf("claimed %d events, want %v", len(events), wantTypes)
+	}
+	for i, event := range events {
+		if event.EventType != wantTypes[i] || event.CustomerIdentifier != "c1" || event.ProductCode != "p1" {
+			t.Errorf("event %d = %s for %s/%s, want %s for c1/p1", i, event.EventType, event.CustomerIdentifier, event.ProductCode, wantTypes[i])
+		}
+	}
+	if events[1].Data != `{"name":"Jane","email":"jane@example.com","phone":"","job_role":"","company":"","country":""}` {
+		t.Errorf("customer.registered data = %s", events[1].Data)
+	}
+
+	results := []OutboxEventResult{
+		{OutboxID: events[0].OutboxID, Status: models.OutboxEventStatusPublished},
+		{OutboxID: events[1].OutboxID, Status: models.OutboxEventStatusFailed, Error: "gave up"},
+		{OutboxID: events[2].OutboxID, Status: models.OutboxEventStatusPending, Error: "unavailable", RetryAt: time.Now().Add(-time.Second)},
+	}
+	for _, result := range results {
+		if err := repository.UpdateOutboxEventResult(ctx, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the event scheduled for a retry is claimed again
+	events, err = repository.ClaimOutboxEvents(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].EventType != models.EventTypeSubscriptionCancelled || events[0].Attempts != 1 {
+		t.Fatalf("claimed %+v, want the retried subscription.cancelled event", events)
+	}
+}
@@ -0,0 +1,64 @@
+package repo
+
+import (
+	"aws-markertplace-integration/db/models"
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// AcquireLease takes the lease name for holder until ttl from now. It reports false while
+// another holder's lease has not expired; the current holder may renew its own lease.
+func (r *repository) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	if name == "" || holder == "" {
+		return false, errors.New("invalid input: missing lease name or holder")
+	}
+
+	now := time.Now()
+	lease := models.JobLease{
+		Name:      name,
+		Holder:    holder,
+		ExpiresAt: now.Add(ttl),
+		UpdatedAt: now,
+	}
+
+	db := r.db.WithContext(ctx)
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&lease)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return true, nil
+	}
+
+	result = db.Model(&models.JobLease{}).
+		Where("name = ? AND (holder = ? OR expires_at < ?)", name, holder, now).
+		Updates(map[string]interface{}{
+			"holder":     holder,
+			"expires_at": lease.ExpiresAt,
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// CreateEntitlementReconciliation stores the report of an entitlement reconciliation run
+func (r *repository) CreateEntitlementReconciliation(ctx context.Context, report *models.EntitlementReconciliation) error {
+	return r.db.WithContext(ctx).Create(report).Error
+}
+
+// ListEntitlementReconciliations returns the most recent entitlement reconciliation reports
+func (r *repository) ListEntitlementReconciliations(ctx context.Context, limit int) ([]models.EntitlementReconciliation, error) {
+	var reports []models.EntitlementReconciliation
+	if err := r.db.WithContext(ctx).
+		Order("reconciliation_id DESC").
+		Limit(limit).
+		Find(&reports).Error; err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
@@ -4,11 +4,14 @@ import (
 	"aws-markertplace-integration/db/models"
 	"context"
 	"errors"
+	"fmt"
 	"reflect"
+	"sort"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/marketplacemetering"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Repository errors
@@ -107,9 +110,42 @@ type CustomerDetailsRequest struct {
 // Repository interface defines the contract for database operations
 type Repository interface {
 	UpdateCustomerBasicInfo(ctx context.Context, info *marketplacemetering.ResolveCustomerOutput) error
-	UpdateEntitlements(ctx context.Context, response EntitlementResponse) error
-	UpdateCustomerAdditionalInfo(ctx context.Context, customerID string, info CustomerAdditionalInfo) error
+	UpdateEntitlements(ctx context.Context, scope EntitlementScope, response EntitlementResponse) (*EntitlementChanges, error)
+	UpdateCustomerAdditionalInfo(ctx context.Context, customerID, productCode string, info CustomerAdditionalInfo) error
 	CheckCustomerRegistration(ctx context.Context, customerIdentifier string) (*CustomerRegistrationStatus, error)
+	GetCustomerByID(ctx context.Context, customerID string) (*models.Customer, error)
+	GetEntitlementsByCustomerID(ctx context.Context, customerID string) ([]models.Entitlement, error)
+	SearchCustomers(ctx context.Context, filter CustomerFilter) ([]models.Customer, error)
+	ListSubscriptionsByCustomerID(ctx context.Context, customerID string) ([]models.Subscription, error)
+	ListProducts(ctx context.Context) ([]models.Product, error)
+	GetProduct(ctx context.Context, productCode string) (*models.Product, error)
+	UpsertProducts(ctx context.Context, products []models.Product) error
+	UpdateProduct(ctx context.Context, productCode string, update ProductUpdate) (*models.Product, error)
+	ListCurrentEntitlements(ctx context.Context, customerIdentifier string, filter EntitlementFilter) ([]models.Entitlement, error)
+	ListEntitlementHistory(ctx context.Context, customerIdentifier string, filter EntitlementFilter) ([]models.Entitlement, error)
+	ListProductCodes(ctx context.Context) ([]string, error)
+	TransitionSubscription(ctx context.Context, customerIdentifier, productCode string, status models.SubscriptionStatus, reason string) (bool, error)
+	GetSubscription(ctx context.Context, customerIdentifier, productCode string) (*models.Subscription, error)
+	ListSubscriptionTransitions(ctx context.Context, customerIdentifier, productCode string) ([]models.SubscriptionTransition, error)
+	CreateUsageRecords(ctx context.Context, records []models.UsageRecord, policy LateUsagePolicy) ([]StoredUsageRecord, error)
+	ClaimUsageBuckets(ctx context.Context, before time.Time, limit int) ([]models.UsageBucket, error)
+	UpdateUsageBucketResults(ctx context.Context, results []UsageBucketResult) error
+	RetryUsageBuckets(ctx context.Context, retries []UsageBucketRetry) error
+	ListUsageDeadLetters(ctx context.Context, limit int) ([]models.UsageDeadLetter, error)
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	CreateEntitlementReconciliation(ctx context.Context, report *models.EntitlementReconciliation) error
+	ListEntitlementReconciliations(ctx context.Context, limit int) ([]models.EntitlementReconciliation, error)
+	ListExpiringEntitlements(ctx context.Context, from, until time.Time) ([]models.Entitlement, error)
+	ListEntitlementExpiryWarnings(ctx context.Context, expiringAfter time.Time) ([]models.EntitlementExpiryWarning, error)
+	ClaimEntitlementExpiryWarning(ctx context.Context, warning *models.EntitlementExpiryWarning) (bool, error)
+	ReleaseEntitlementExpiryWarning(ctx context.Context, warningID int64) error
+	RefreshCustomerActivity(ctx context.Context, now time.Time) (deactivated, reactivated []string, err error)
+	CreateWebhookDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error
+	ClaimWebhookDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error)
+	UpdateWebhookDeliveryResult(ctx context.Context, result WebhookDeliveryResult) error
+	ListWebhookDeliveries(ctx context.Context, status models.WebhookDeliveryStatus, limit int) ([]models.WebhookDelivery, error)
+	ClaimOutboxEvents(ctx context.Context, limit int) ([]models.OutboxEvent, error)
+	UpdateOutboxEventResult(ctx context.Context, result OutboxEventResult) error
 }
 
 // repository implements the Repository interface
@@ -134,27 +170,41 @@ func (r *repository) UpdateCustomerBasicInfo(ctx context.Context, info *marketpl
 	}
 
 	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		// Upsert customer
-		if err := tx.Exec(`
-			INSERT INTO customers (customer_identifier, aws_account_id)
-			VALUES (?, ?)
-			ON DUPLICATE KEY UPDATE
-				aws_account_id = VALUES(aws_account_id)
-		`, *info.CustomerIdentifier, *info.CustomerAWSAccountId).Error; err != nil {
-			return err
+		// Insert the customer, or update the account of a known one. Whether the insert took
+		// effect decides on customer.created, so that concurrent resolves emit it only once.
+		created := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "customer_identifier"}},
+			DoNothing: true,
+		}).Select("customer_identifier", "aws_account_id").Create(&models.Customer{
+			CustomerIdentifier: *info.CustomerIdentifier,
+			AWSAccountID:       *info.CustomerAWSAccountId,
+		})
+		if created.Error != nil {
+			return created.Error
+		}
+		if created.RowsAffected == 0 {
+			if err := tx.Model(&models.Customer{}).
+				Where("customer_identifier = ?", *info.CustomerIdentifier).
+				Update("aws_account_id", *info.CustomerAWSAccountId).Error; err != nil {
+				return err
+			}
 		}
 
-		// Upsert product
-		if err := tx.Exec(`
-			INSERT INTO products (product_code)
-			VALUES (?)
-			ON DUPLICATE KEY UPDATE
-				product_code = VALUES(product_code)
-		`, *info.ProductCode).Error; err != nil {
+		// Insert product if it is not known yet
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "product_code"}},
+			DoNothing: true,
+		}).Select("product_code").Create(&models.Product{
+			ProductCode: *info.ProductCode,
+		}).Error; err != nil {
 			return err
 		}
 
-		return nil
+		if created.RowsAffected == 0 {
+			return nil
+		}
+		return writeOutboxEvent(tx, models.EventTypeCustomerCreated, *info.CustomerIdentifier, *info.ProductCode,
+			models.CustomerCreatedEventData{AWSAccountID: *info.CustomerAWSAccountId})
 	})
 }
 
@@ -177,6 +227,15 @@ func getResolveCustomerValues(info *marketplacemetering.ResolveCustomerOutput) (
 	return *info.CustomerIdentifier, *info.CustomerAWSAccountId, *info.ProductCode, nil
 }
 
+// expirationTime converts an optional Unix timestamp to a time
+func expirationTime(expiration *int64) *time.Time {
+	if expiration == nil {
+		return nil
+	}
+	t := time.Unix(*expiration, 0).UTC()
+	return &t
+}
+
 // determineValueType determines the type of value and returns appropriate EntitlementValue
 func determineValueType(value EntitlementValue) (*models.EntitlementValue, error) {
 	ev := &models.EntitlementValue{}
@@ -205,7 +264,7 @@ func determineValueType(value EntitlementValue) (*models.EntitlementValue, error
 	return nil, ErrInvalidValue
 }
 
-// UpdateEntitlements updates or creates entitlements based on API response
+// compareEntitlementValues reports whether two entitlement values are equal
 func compareEntitlementValues(existing *models.EntitlementValue, new *models.EntitlementValue) bool {
 	if existing.ValueType != new.ValueType {
 		return false
@@ -225,18 +284,31 @@ func compareEntitlementValues(existing *models.EntitlementValue, new *models.Ent
 	}
 }
 
-// UpdateEntitlements updates or creates entitlements based on API response
-func (r *repository) UpdateEntitlements(ctx context.Context, response EntitlementResponse) error {
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+// UpdateEntitlements records the entitlements of a complete API response for scope. A new
+// version is added for every dimension whose value changed, and dimensions of the scope that
+// are missing from the response get a version marking them as removed.
+func (r *repository) UpdateEntitlements(ctx context.Context, scope EntitlementScope, response EntitlementResponse) (*EntitlementChanges, error) {
+	if scope.ProductCode == "" {
+		return nil, errors.New("invalid input: missing product code")
+	}
+
+	changes := &EntitlementChanges{}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		latest, err := latestEntitlementVersions(tx, scope)
+		if err != nil {
+			return err
+		}
+
 		for _, ent := range response.Entitlements {
-			// First check if entitlement exists with the same customer_identifier, product_code, and dimension
-			var existing models.Entitlement
-			result := tx.Preload("Value").Where(
-				"customer_identifier = ? AND product_code = ? AND dimension = ?",
-				ent.CustomerIdentifier,
-				ent.ProductCode,
-				ent.Dimension,
-			).Order("created_at DESC").First(&existing)
+			key := EntitlementDimension{
+				CustomerIdentifier: ent.CustomerIdentifier,
+				ProductCode:        ent.ProductCode,
+				Dimension:          ent.Dimension,
+			}
+			if !scope.contains(key) {
+				return fmt.Errorf("invalid input: entitlement %s/%s/%s is outside the update scope",
+					ent.CustomerIdentifier, ent.ProductCode, ent.Dimension)
+			}
 
 			// Create new entitlement value for comparison
 			newEntValue, err := determineValueType(ent.Value)
@@ -244,91 +316,115 @@ func (r *repository) UpdateEntitlements(ctx context.Context, response Entitlemen
 				return err
 			}
 
-			// If no existing entitlement found, create new one
-			if result.Error == gorm.ErrRecordNotFound {
-				// Create value
-				if err := tx.Create(newEntValue).Error; err != nil {
-					return err
-				}
-
-				// Convert Unix timestamp to string
-				expirationDate := time.Unix(*ent.ExpirationDate, 0).Format(time.RFC3339)
-
-				// Create new entitlement
-				newEntitlement := models.Entitlement{
-					CustomerIdentifier: ent.CustomerIdentifier,
-					ProductCode:        ent.ProductCode,
-					Dimension:          ent.Dimension,
-					ExpirationDate:     expirationDate,
-					ValueID:            newEntValue.ValueID,
-				}
-
-				if err := tx.Create(&newEntitlement).Error; err != nil {
+			existing, found := latest[key]
+			delete(latest, key)
+			expirationDate := expirationTime(ent.ExpirationDate)
+
+			// If values are the same, only record that the current version was confirmed
+			// and carry over a renewed expiration date
+			if found && !existing.Removed && existing.Value != nil && compareEntitlementValues(existing.Value, newEntValue) {
+				if err := tx.Model(&models.Entitlement{}).
+					Where("entitlement_id = ?", existing.EntitlementID).
+					Updates(map[string]interface{}{
+						"expiration_date": expirationDate,
+						"updated_at":      time.Now(),
+					}).Error; err != nil {
 					return err
 				}
-
+				changes.Unchanged++
 				continue
-			} else if result.Error != nil {
-				return result.Error
 			}
 
-			// Compare values only if entitlement exists
-			if existing.Value != nil {
-				// If values are the same, skip this entitlement
-				if compareEntitlementValues(existing.Value, newEntValue) {
-					continue
-				}
+			// Values are different or the dimension is new, create new entitlement version
+			if err := tx.Create(newEntValue).Error; err != nil {
+				return err
+			}
 
-				// Values are different, create new entitlement record
-				if err := tx.Create(newEntValue).Error; err != nil {
-					return err
-				}
+			newEntitlement := models.Entitlement{
+				CustomerIdentifier: ent.CustomerIdentifier,
+				ProductCode:        ent.ProductCode,
+				Dimension:          ent.Dimension,
+				ExpirationDate:     expirationDate,
+				ValueID:            newEntValue.ValueID,
+			}
 
-				// Convert Unix timestamp to string
-				expirationDate := time.Unix(*ent.ExpirationDate, 0).Format(time.RFC3339)
+			if err := tx.Create(&newEntitlement).Error; err != nil {
+				return err
+			}
 
-				// Create new entitlement with new value
-				newEntitlement := models.Entitlement{
-					CustomerIdentifier: ent.CustomerIdentifier,
-					ProductCode:        ent.ProductCode,
-					Dimension:          ent.Dimension,
-					ExpirationDate:     expirationDate,
-					ValueID:            newEntValue.ValueID,
-				}
+			if found && !existing.Removed {
+				changes.Changed = append(changes.Changed, key)
+			} else {
+				changes.Created = append(changes.Created, key)
+			}
+		}
 
-				if err := tx.Create(&newEntitlement).Error; err != nil {
-					return err
-				}
+		// Whatever is left of the scope has vanished from the response
+		vanished := make([]EntitlementDimension, 0, len(latest))
+		for key, existing := range latest {
+			if !existing.Removed {
+				vanished = append(vanished, key)
 			}
 		}
-		return nil
+		sort.Slice(vanished, func(i, j int) bool {
+			return vanished[i].less(vanished[j])
+		})
+
+		for _, key := range vanished {
+			removed := models.Entitlement{
+				CustomerIdentifier: key.CustomerIdentifier,
+				ProductCode:        key.ProductCode,
+				Dimension:          key.Dimension,
+				ExpirationDate:     latest[key].ExpirationDate,
+				Removed:            true,
+			}
+			if err := tx.Create(&removed).Error; err != nil {
+				return err
+			}
+			changes.Removed = append(changes.Removed, key)
+		}
+		return writeEntitlementChangedEvents(tx, changes)
 	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
 }
 
-// UpdateCustomerAdditionalInfo updates additional customer information
-func (r *repository) UpdateCustomerAdditionalInfo(ctx context.Context, customerID string, info CustomerAdditionalInfo) error {
-	result := r.db.WithContext(ctx).Exec(`
-		UPDATE customers 
-		SET 
-			name = ?,
-			email = ?,
-			phone = ?,
-			job_role = ?,
-			company = ?,
-			country = ?
-		WHERE customer_identifier = ?
-	`, info.Name, info.Email, info.Phone, info.JobRole,
-		info.Company, info.Country, customerID)
-
-	if result.Error != nil {
-		return result.Error
-	}
+// UpdateCustomerAdditionalInfo updates additional customer information and stores a
+// customer.registered event for the product the customer registered for
+func (r *repository) UpdateCustomerAdditionalInfo(ctx context.Context, customerID, productCode string, info CustomerAdditionalInfo) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec(`
+			UPDATE customers 
+			SET 
+				name = ?,
+				email = ?,
+				phone = ?,
+				job_role = ?,
+				company = ?,
+				country = ?
+			WHERE customer_identifier = ?
+		`, info.Name, info.Email, info.Phone, info.JobRole,
+			info.Company, info.Country, customerID)
+
+		if result.Error != nil {
+			return result.Error
+		}
 
-	if result.RowsAffected == 0 {
-		return ErrCustomerNotFound
-	}
+		if result.RowsAffected == 0 {
+			return ErrCustomerNotFound
+		}
 
-	return nil
+		return writeOutboxEvent(tx, models.EventTypeCustomerRegistered, customerID, productCode, models.CustomerRegisteredEventData{
+			Name:    info.Name,
+			Email:   info.Email,
+			Phone:   info.Phone,
+			JobRole: info.JobRole,
+			Company: info.Company,
+			Country: info.Country,
+		})
+	})
 }
 
 // Additional helper functions for common queries
@@ -345,13 +441,20 @@ func (r *repository) GetCustomerByID(ctx context.Context, customerID string) (*m
 	return &customer, nil
 }
 
-// GetEntitlementsByCustomerID retrieves all entitlements for a customer
+// GetEntitlementsByCustomerID retrieves the current entitlements of a customer across all products
 func (r *repository) GetEntitlementsByCustomerID(ctx context.Context, customerID string) ([]models.Entitlement, error) {
+	db := r.db.WithContext(ctx)
+	latest := db.Model(&models.Entitlement{}).
+		Select("MAX(entitlement_id)").
+		Where("customer_identifier = ?", customerID).
+		Group("product_code, dimension")
+
 	var entitlements []models.Entitlement
-	if err := r.db.WithContext(ctx).
+	if err := db.
 		Preload("Value").
 		Preload("Product").
-		Where("customer_identifier = ?", customerID).
+		Where("entitlement_id IN (?) AND removed = ?", latest, false).
+		Order("product_code, dimension").
 		Find(&entitlements).Error; err != nil {
 		return nil, err
 	}
@@ -399,6 +502,7 @@ func (r *repository) CheckCustomerRegistration(ctx context.Context, customerIden
 	// If no customer found, return false and empty product name
 	if result.CustomerIdentifier == "" {
 		return &CustomerRegistrationStatus{
+			CustomerExists:    false,
 			NeedsRegistration: false,
 			ProductName:       "",
 		}, nil
@@ -418,6 +522,7 @@ func (r *repository) CheckCustomerRegistration(ctx context.Context, customerIden
 	}
 
 	return &CustomerRegistrationStatus{
+		CustomerExists:    true,
 		NeedsRegistration: needsRegistration,
 		ProductName:       productName,
 	}, nil
@@ -425,6 +530,7 @@ func (r *repository) CheckCustomerRegistration(ctx context.Context, customerIden
 
 // CustomerRegistrationStatus represents the response for customer registration check
 type CustomerRegistrationStatus struct {
+	CustomerExists    bool   `json:"customer_exists"`
 	NeedsRegistration bool   `json:"needs_registration"`
 	ProductName       string `json:"product_name,omitempty"`
 }
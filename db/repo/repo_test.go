@@ -0,0 +1,132 @@
+package repo
+
+import (
+	"aws-markertplace-integration/db"
+	"aws-markertplace-integration/db/migrations"
+	"aws-markertplace-integration/db/models"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacemetering"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// newTestRepository returns a repository backed by a migrated SQLite file
+func newTestRepository(t *testing.T) (Repository, *gorm.DB) {
+	t.Helper()
+	database, err := db.Open("sqlite://" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrations.New(database, zap.NewNop().Sugar()).Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return NewRepository(database), database
+}
+
+// countOutboxEvents returns how many events of eventType are stored
+func countOutboxEvents(t *testing.T, database *gorm.DB, eventType string) int64 {
+	t.Helper()
+	var count int64
+	if err := database.Model(&models.OutboxEvent{}).Where("event_type = ?", eventType).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestUpdateCustomerBasicInfo(t *testing.T) {
+	ctx := context.Background()
+	repository, database := newTestRepository(t)
+
+	for _, accountID := range []string{"111111111111", "222222222222"} {
+		if err := repository.UpdateCustomerBasicInfo(ctx, &marketplacemetering.ResolveCustomerOutput{
+			CustomerIdentifier:   aws.String("c1"),
+			CustomerAWSAccountId: aws.String(accountID),
+			ProductCode:          aws.String("p1"),
+		}); err != nil {
+			t.Fatalf("UpdateCustomerBasicInfo() error = %v", err)
+		}
+	}
+
+	customer, err := repository.GetCustomerByID(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if customer.AWSAccountID != "222222222222" {
+		t.Errorf("AWSAccountID = %q, want the account of the last resolve", customer.AWSAccountID)
+	}
+	if _, err := repository.GetProduct(ctx, "p1"); err != nil {
+		t.Errorf("GetProduct() error = %v", err)
+	}
+	if count := countOutboxEvents(t, database, models.EventTypeCustomerCreated); count != 1 {
+		t.Errorf("stored %d %s events, want 1", count, models.EventTypeCustomerCreated)
+	}
+}
+
+func TestUpdateEntitlements(t *testing.T) {
+	ctx := context.Background()
+	repository, _ := newTestRepository(t)
+	scope := EntitlementScope{ProductCode: "p1", CustomerIdentifier: "c1"}
+	entitlement := func(dimension string, value EntitlementValue) Entitlement {
+		return Entitlement{CustomerIdentifier: "c1", ProductCode: "p1", Dimension: dimension, Value: value}
+	}
+
+	steps := []struct {
+		name          string
+		entitlements  []Entitlement
+		wantCreated   int
+		wantChanged   int
+		wantRemoved   int
+		wantUnchanged int
+	}{
+		{
+			name: "new dimensions",
+			entitlements: []Entitlement{
+				entitlement("storage", EntitlementValue{DoubleValue: aws.Float64(1.5)}),
+				entitlement("tier", EntitlementValue{StringValue: aws.String("gold")}),
+			},
+			wantCreated: 2,
+		},
+		{
+			name: "same values",
+			entitlements: []Entitlement{
+				entitlement("storage", EntitlementValue{DoubleValue: aws.Float64(1.5)}),
+				entitlement("tier", EntitlementValue{StringValue: aws.String("gold")}),
+			},
+			wantUnchanged: 2,
+		},
+		{
+			name: "changed and vanished dimensions",
+			entitlements: []Entitlement{
+				entitlement("storage", EntitlementValue{DoubleValue: aws.Float64(2.5)}),
+			},
+			wantChanged: 1,
+			wantRemoved: 1,
+		},
+	}
+	for _, step := range steps {
+		changes, err := repository.UpdateEntitlements(ctx, scope, EntitlementResponse{Entitlements: step.entitlements})
+		if err != nil {
+			t.Fatalf("%s: UpdateEntitlements() error = %v", step.name, err)
+		}
+		if len(changes.Created) != step.wantCreated || len(changes.Changed) != step.wantChanged ||
+			len(changes.Removed) != step.wantRemoved || changes.Unchanged != step.wantUnchanged {
+			t.Fatalf("%s: changes = %+v", step.name, changes)
+		}
+	}
+
+	current, err := repository.ListCurrentEntitlements(ctx, "c1", EntitlementFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 1 || current[0].Dimension != "storage" {
+		t.Fatalf("ListCurrentEntitlements() = %+v, want only the storage dimension", current)
+	}
+	value := current[0].Value
+	if value == nil || value.ValueType != models.ValueTypeDouble || value.DoubleValue == nil || *value.DoubleValue != 2.5 {
+		t.Fatalf("current storage value = %+v, want double 2.5", value)
+	}
+}
@@ -0,0 +1,184 @@
+package repo
+
+import (
+	"aws-markertplace-integration/db/models"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Subscription errors
+var (
+	ErrSubscriptionNotFound          = errors.New("subscription not found")
+	ErrInvalidSubscriptionTransition = errors.New("invalid subscription transition")
+)
+
+// subscriptionTransitions lists the states a subscription may move to from each state. The
+// empty state stands for a subscription that does not exist yet; the first event we receive
+// for it is taken as authoritative, since it may predate the subscription being recorded.
+// Registration activates subscriptions that already have entitlements, so a subscribe-fail
+// notification may still arrive for an active subscription.
+var subscriptionTransitions = map[models.SubscriptionStatus][]models.SubscriptionStatus{
+	"": {
+		models.SubscriptionStatusPending,
+		models.SubscriptionStatusActive,
+		models.SubscriptionStatusFailed,
+		models.SubscriptionStatusPendingCancellation,
+		models.SubscriptionStatusCancelled,
+	},
+	models.SubscriptionStatusPending: {
+		models.SubscriptionStatusActive,
+		models.SubscriptionStatusFailed,
+		models.SubscriptionStatusPendingCancellation,
+		models.SubscriptionStatusCancelled,
+	},
+	models.SubscriptionStatusActive: {
+		models.SubscriptionStatusFailed,
+		models.SubscriptionStatusPendingCancellation,
+		models.SubscriptionStatusCancelled,
+	},
+	models.SubscriptionStatusPendingCancellation: {
+		models.SubscriptionStatusCancelled,
+	},
+	models.SubscriptionStatusFailed: {
+		models.SubscriptionStatusPending,
+		models.SubscriptionStatusActive,
+	},
+	models.SubscriptionStatusCancelled: {
+		models.SubscriptionStatusPending,
+		models.SubscriptionStatusActive,
+	},
+}
+
+// canTransitionSubscription reports whether a subscription may move from one state to another
+func canTransitionSubscription(from, to models.SubscriptionStatus) bool {
+	for _, status := range subscriptionTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionSubscription moves the subscription of a customer for a product to status and
+// records the transition, reporting whether the state changed. Moving a subscription to the
+// state it is already in is a no-op; transitions not allowed by the state machine return
+// ErrInvalidSubscriptionTransition. Cancellations store a subscription.cancelled event.
+func (r *repository) TransitionSubscription(ctx context.Context, customerIdentifier, productCode string, status models.SubscriptionStatus, reason string) (bool, error) {
+	if customerIdentifier == "" || productCode == "" {
+		return false, errors.New("invalid input: missing customer identifier or product code")
+	}
+	if _, ok := subscriptionTransitions[status]; !ok || status == "" {
+		return false, fmt.Errorf("invalid input: unknown subscription status %q", status)
+	}
+
+	changed := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		var subscription models.Subscription
+		found, err := lockSubscription(tx, customerIdentifier, productCode, &subscription)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			subscription = models.Subscription{
+				CustomerIdentifier: customerIdentifier,
+				ProductCode:        productCode,
+				Status:             status,
+				CreatedAt:          now,
+				UpdatedAt:          now,
+			}
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&subscription)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				// Created concurrently; apply the transition to the stored subscription instead
+				if _, err := lockSubscription(tx, customerIdentifier, productCode, &subscription); err != nil {
+					return err
+				}
+				found = true
+			}
+		}
+
+		from := models.SubscriptionStatus("")
+		if found {
+			from = subscription.Status
+			if from == status {
+				return nil
+			}
+			if !canTransitionSubscription(from, status) {
+				return fmt.Errorf("%w: %s to %s", ErrInvalidSubscriptionTransition, from, status)
+			}
+			if err := tx.Model(&models.Subscription{}).
+				Where("customer_identifier = ? AND product_code = ?", customerIdentifier, productCode).
+				Updates(map[string]interface{}{
+					"status":     status,
+					"updated_at": now,
+				}).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Create(&models.SubscriptionTransition{
+			CustomerIdentifier: customerIdentifier,
+			ProductCode:        productCode,
+			FromStatus:         from,
+			ToStatus:           status,
+			Reason:             reason,
+			CreatedAt:          now,
+		}).Error; err != nil {
+			return err
+		}
+		changed = true
+
+		if status != models.SubscriptionStatusCancelled {
+			return nil
+		}
+		return writeOutboxEvent(tx, models.EventTypeSubscriptionCancelled, customerIdentifier, productCode,
+			models.SubscriptionCancelledEventData{Action: reason})
+	})
+	return changed, err
+}
+
+// lockSubscription loads a subscription for update, reporting whether it exists
+func lockSubscription(tx *gorm.DB, customerIdentifier, productCode string, subscription *models.Subscription) (bool, error) {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("customer_identifier = ? AND product_code = ?", customerIdentifier, productCode).
+		Take(subscription).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetSubscription returns the subscription of a customer for a product
+func (r *repository) GetSubscription(ctx context.Context, customerIdentifier, productCode string) (*models.Subscription, error) {
+	var subscription models.Subscription
+	err := r.db.WithContext(ctx).
+		Where("customer_identifier = ? AND product_code = ?", customerIdentifier, productCode).
+		Take(&subscription).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// ListSubscriptionTransitions returns the transitions of a subscription, oldest first
+func (r *repository) ListSubscriptionTransitions(ctx context.Context, customerIdentifier, productCode string) ([]models.SubscriptionTransition, error) {
+	var transitions []models.SubscriptionTransition
+	err := r.db.WithContext(ctx).
+		Where("customer_identifier = ? AND product_code = ?", customerIdentifier, productCode).
+		Order("created_at, transition_id").
+		Find(&transitions).Error
+	return transitions, err
+}
@@ -0,0 +1,73 @@
+package repo
+
+import (
+	"aws-markertplace-integration/db/models"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTransitionSubscription(t *testing.T) {
+	tests := []struct {
+		name        string
+		from        models.SubscriptionStatus
+		to          models.SubscriptionStatus
+		wantChanged bool
+		wantErr     error
+	}{
+		{name: "new subscription", to: models.SubscriptionStatusPending, wantChanged: true},
+		{name: "first event is authoritative", to: models.SubscriptionStatusCancelled, wantChanged: true},
+		{name: "pending to active", from: models.SubscriptionStatusPending, to: models.SubscriptionStatusActive, wantChanged: true},
+		{name: "pending to failed", from: models.SubscriptionStatusPending, to: models.SubscriptionStatusFailed, wantChanged: true},
+		{name: "active to failed", from: models.SubscriptionStatusActive, to: models.SubscriptionStatusFailed, wantChanged: true},
+		{name: "active to pending cancellation", from: models.SubscriptionStatusActive, to: models.SubscriptionStatusPendingCancellation, wantChanged: true},
+		{name: "pending cancellation to cancelled", from: models.SubscriptionStatusPendingCancellation, to: models.SubscriptionStatusCancelled, wantChanged: true},
+		{name: "cancelled to active", from: models.SubscriptionStatusCancelled, to: models.SubscriptionStatusActive, wantChanged: true},
+		{name: "failed to active", from: models.SubscriptionStatusFailed, to: models.SubscriptionStatusActive, wantChanged: true},
+		{name: "same status", from: models.SubscriptionStatusActive, to: models.SubscriptionStatusActive},
+		{name: "active to pending", from: models.SubscriptionStatusActive, to: models.SubscriptionStatusPending, wantErr: ErrInvalidSubscriptionTransition},
+		{name: "pending cancellation to active", from: models.SubscriptionStatusPendingCancellation, to: models.SubscriptionStatusActive, wantErr: ErrInvalidSubscriptionTransition},
+		{name: "failed to cancelled", from: models.SubscriptionStatusFailed, to: models.SubscriptionStatusCancelled, wantErr: ErrInvalidSubscriptionTransition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repository, _ := newTestRepository(t)
+			wantTransitions := 0
+			if tt.from != "" {
+				if _, err := repository.TransitionSubscription(ctx, "c1", "p1", tt.from, "setup"); err != nil {
+					t.Fatal(err)
+				}
+				wantTransitions++
+			}
+
+			changed, err := repository.TransitionSubscription(ctx, "c1", "p1", tt.to, "test")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("TransitionSubscription() error = %v, want %v", err, tt.wantErr)
+			}
+			if changed != tt.wantChanged {
+				t.Fatalf("TransitionSubscription() changed = %v, want %v", changed, tt.wantChanged)
+			}
+
+			wantStatus := tt.from
+			if changed {
+				wantStatus = tt.to
+				wantTransitions++
+			}
+			subscription, err := repository.GetSubscription(ctx, "c1", "p1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subscription.Status != wantStatus {
+				t.Errorf("status = %s, want %s", subscription.Status, wantStatus)
+			}
+			transitions, err := repository.ListSubscriptionTransitions(ctx, "c1", "p1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(transitions) != wantTransitions {
+				t.Errorf("recorded %d transitions, want %d", len(transitions), wantTransitions)
+			}
+		})
+	}
+}
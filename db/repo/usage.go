@@ -0,0 +1,370 @@
+package repo
+
+import (
+	"aws-markertplace-integration/db/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LateUsagePolicy decides what happens to usage that arrives for an hour that is already closed
+type LateUsagePolicy string
+
+const (
+	// LateUsageCarryForward adds late usage to the bucket of the current hour
+	LateUsageCarryForward LateUsagePolicy = "carry-forward"
+	// LateUsageReject refuses late usage
+	LateUsageReject LateUsagePolicy = "reject"
+)
+
+// usageBucketMaxAge is how far back an hour stays open for new usage. AWS only accepts
+// metering records with recent timestamps, so older hours are treated as closed.
+const usageBucketMaxAge = time.Hour
+
+// maxMessageLength is the size of the message columns of the usage tables
+const maxMessageLength = 1024
+
+// staleSubmissionTimeout is after how long a bucket stuck in submitting is claimed again
+const staleSubmissionTimeout = 10 * time.Minute
+
+// StoredUsageRecord represents a usage record after ingestion
+type StoredUsageRecord struct {
+	Record    models.UsageRecord
+	Duplicate bool
+	Rejected  bool
+}
+
+// UsageBucketResult represents the final outcome of submitting a usage bucket to AWS.
+// Failed buckets are moved to the dead-letter table with Reason.
+type UsageBucketResult struct {
+	UsageBucketID    int64
+	Status           models.UsageBucketStatus
+	MeteringStatus   string
+	MeteringRecordID *string
+	Reason           string
+	Message          string
+}
+
+// UsageBucketRetry schedules another submission attempt for a usage bucket
+type UsageBucketRetry struct {
+	UsageBucketID int64
+	NextAttemptAt time.Time
+	Message       string
+}
+
+// CreateUsageRecords stores usage records and adds their quantity to the hourly bucket they
+// belong to. Records whose idempotency key was already ingested are not stored again; the
+// existing record is returned instead. Usage for closed hours is handled according to policy.
+func (r *repository) CreateUsageRecords(ctx context.Context, records []models.UsageRecord, policy LateUsagePolicy) ([]StoredUsageRecord, error) {
+	stored := make([]StoredUsageRecord, 0, len(records))
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, record := range records {
+			if record.IdempotencyKey != nil {
+				var existing models.UsageRecord
+				err := tx.Where("idempotency_key = ?", *record.IdempotencyKey).First(&existing).Error
+				if err == nil {
+					stored = append(stored, StoredUsageRecord{Record: existing, Duplicate: true})
+					continue
+				}
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					return err
+				}
+			}
+
+			currentHour := time.Now().UTC().Truncate(time.Hour)
+			hour := record.UsageTimestamp.UTC().Truncate(time.Hour)
+			bucketID, err := r.addToUsageBucket(tx, record, hour, currentHour)
+			if err != nil {
+				return err
+			}
+			if bucketID == 0 {
+				if policy == LateUsageReject {
+					stored = append(stored, StoredUsageRecord{Record: record, Rejected: true})
+					continue
+				}
+				// The current hour is never submitted before it closes, so it is always open
+				if bucketID, err = r.addToUsageBucket(tx, record, currentHour, currentHour); err != nil {
+					return err
+				}
+				if bucketID == 0 {
+					return errors.New("current usage hour is not open")
+				}
+				record.CarriedForward = true
+			}
+
+			record.UsageBucketID = &bucketID
+			if err := tx.Create(&record).Error; err != nil {
+				return err
+			}
+			stored = append(stored, StoredUsageRecord{Record: record})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// addToUsageBucket adds the record's quantity to its bucket for the given hour. It returns 0
+// when the hour is closed, either because it is too old or because it was already submitted.
+func (r *repository) addToUsageBucket(tx *gorm.DB, record models.UsageRecord, hour, currentHour time.Time) (int64, error) {
+	if hour.Before(currentHour.Add(-usageBucketMaxAge)) {
+		return 0, nil
+	}
+
+	now := time.Now()
+	bucket := models.UsageBucket{
+		ProductCode:        record.ProductCode,
+		CustomerIdentifier: record.CustomerIdentifier,
+		Dimension:          record.Dimension,
+		UsageHour:          hour,
+		Status:             models.UsageBucketStatusPending,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&bucket).Error; err != nil {
+		return 0, err
+	}
+
+	var existing models.UsageBucket
+	if err := tx.Where(
+		"product_code = ? AND customer_identifier = ? AND dimension = ? AND usage_hour = ?",
+		record.ProductCode, record.CustomerIdentifier, record.Dimension, hour,
+	).First(&existing).Error; err != nil {
+		return 0, err
+	}
+
+	result := tx.Model(&models.UsageBucket{}).
+		Where("usage_bucket_id = ? AND status = ?", existing.UsageBucketID, models.UsageBucketStatusPending).
+		Updates(map[string]any{
+			"quantity":   gorm.Expr("quantity + ?", record.Quantity),
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, nil
+	}
+
+	if err := addUsageAllocations(tx, existing.UsageBucketID, record); err != nil {
+		return 0, err
+	}
+	return existing.UsageBucketID, nil
+}
+
+// addUsageAllocations adds the record's allocations to the allocations of its bucket. Usage
+// without allocations is attributed to the untagged allocation, so that the allocations of a
+// bucket always add up to its quantity.
+func addUsageAllocations(tx *gorm.DB, bucketID int64, record models.UsageRecord) error {
+	allocations := record.Allocations
+	if len(allocations) == 0 {
+		allocations = []models.UsageRecordAllocation{{Quantity: record.Quantity}}
+	}
+
+	for _, allocation := range allocations {
+		tags := sortedUsageTags(allocation.Tags)
+		tagsHash, err := usageTagsHash(tags)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.UsageAllocation{
+			UsageBucketID: bucketID,
+			TagsHash:      tagsHash,
+			Tags:          tags,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.UsageAllocation{}).
+			Where("usage_bucket_id = ? AND tags_hash = ?", bucketID, tagsHash).
+			Update("quantity", gorm.Expr("quantity + ?", allocation.Quantity)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedUsageTags returns a copy of tags ordered by key
+func sortedUsageTags(tags []models.UsageTag) []models.UsageTag {
+	sorted := append([]models.UsageTag(nil), tags...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted
+}
+
+// usageTagsHash identifies a set of sorted tags
+func usageTagsHash(tags []models.UsageTag) (string, error) {
+	encoded, err := json.Marshal(tags)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ClaimUsageBuckets marks pending buckets of hours before the given time that are due for an
+// attempt as submitting and returns them. Buckets left in submitting by an interrupted run are
+// claimed again.
+func (r *repository) ClaimUsageBuckets(ctx context.Context, before time.Time, limit int) ([]models.UsageBucket, error) {
+	var buckets []models.UsageBucket
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("usage_hour < ?", before).
+			Where("(status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)) OR (status = ? AND updated_at < ?)",
+				models.UsageBucketStatusPending,
+				now,
+				models.UsageBucketStatusSubmitting,
+				now.Add(-staleSubmissionTimeout)).
+			Order("usage_hour").
+			Limit(limit).
+			Find(&buckets).Error; err != nil {
+			return err
+		}
+		if len(buckets) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, 0, len(buckets))
+		for i := range buckets {
+			ids = append(ids, buckets[i].UsageBucketID)
+			buckets[i].Status = models.UsageBucketStatusSubmitting
+		}
+		if err := tx.Model(&models.UsageBucket{}).
+			Where("usage_bucket_id IN ?", ids).
+			Updates(map[string]any{
+				"status":     models.UsageBucketStatusSubmitting,
+				"updated_at": now,
+			}).Error; err != nil {
+			return err
+		}
+
+		var allocations []models.UsageAllocation
+		if err := tx.Where("usage_bucket_id IN ?", ids).
+			Order("usage_allocation_id").
+			Find(&allocations).Error; err != nil {
+			return err
+		}
+		byBucket := make(map[int64][]models.UsageAllocation, len(buckets))
+		for _, allocation := range allocations {
+			byBucket[allocation.UsageBucketID] = append(byBucket[allocation.UsageBucketID], allocation)
+		}
+		for i := range buckets {
+			buckets[i].Allocations = byBucket[buckets[i].UsageBucketID]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+// UpdateUsageBucketResults stores the final submission results of usage buckets and moves
+// failed buckets to the dead-letter table
+func (r *repository) UpdateUsageBucketResults(ctx context.Context, results []UsageBucketResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+	now := time.Now()
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, result := range results {
+			var bucket models.UsageBucket
+			if err := tx.First(&bucket, "usage_bucket_id = ?", result.UsageBucketID).Error; err != nil {
+				return err
+			}
+
+			updates := map[string]any{
+				"status":             result.Status,
+				"metering_status":    result.MeteringStatus,
+				"metering_record_id": result.MeteringRecordID,
+				"status_message":     truncateMessage(result.Message),
+				"attempts":           bucket.Attempts + 1,
+				"next_attempt_at":    nil,
+				"updated_at":         now,
+			}
+			if result.Status == models.UsageBucketStatusSubmitted {
+				updates["submitted_at"] = now
+			}
+			if err := tx.Model(&models.UsageBucket{}).
+				Where("usage_bucket_id = ?", result.UsageBucketID).
+				Updates(updates).Error; err != nil {
+				return err
+			}
+
+			if result.Status != models.UsageBucketStatusFailed {
+				continue
+			}
+			deadLetter := models.UsageDeadLetter{
+				UsageBucketID:      bucket.UsageBucketID,
+				ProductCode:        bucket.ProductCode,
+				CustomerIdentifier: bucket.CustomerIdentifier,
+				Dimension:          bucket.Dimension,
+				UsageHour:          bucket.UsageHour,
+				Quantity:           bucket.Quantity,
+				Reason:             result.Reason,
+				Message:            truncateMessage(result.Message),
+				Attempts:           bucket.Attempts + 1,
+				CreatedAt:          now,
+			}
+			if err := tx.Create(&deadLetter).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RetryUsageBuckets returns claimed buckets to pending and schedules their next attempt
+func (r *repository) RetryUsageBuckets(ctx context.Context, retries []UsageBucketRetry) error {
+	if len(retries) == 0 {
+		return nil
+	}
+	now := time.Now()
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, retry := range retries {
+			if err := tx.Model(&models.UsageBucket{}).
+				Where("usage_bucket_id = ? AND status = ?", retry.UsageBucketID, models.UsageBucketStatusSubmitting).
+				Updates(map[string]any{
+					"status":          models.UsageBucketStatusPending,
+					"status_message":  truncateMessage(retry.Message),
+					"attempts":        gorm.Expr("attempts + 1"),
+					"next_attempt_at": retry.NextAttemptAt,
+					"updated_at":      now,
+				}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListUsageDeadLetters retrieves the most recent dead-lettered usage buckets
+func (r *repository) ListUsageDeadLetters(ctx context.Context, limit int) ([]models.UsageDeadLetter, error) {
+	var deadLetters []models.UsageDeadLetter
+	if err := r.db.WithContext(ctx).
+		Order("dead_letter_id DESC").
+		Limit(limit).
+		Find(&deadLetters).Error; err != nil {
+		return nil, err
+	}
+	return deadLetters, nil
+}
+
+// truncateMessage shortens message to fit the message columns
+func truncateMessage(message string) string {
+	if len(message) <= maxMessageLength {
+		return message
+	}
+	return message[:maxMessageLength]
+}
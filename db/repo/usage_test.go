@@ -0,0 +1,211 @@
+package repo
+
+import (
+	"aws-markertplace-integration/db/models"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// usageOutcome names the ingestion outcome of a stored usage record
+func usageOutcome(stored StoredUsageRecord) string {
+	switch {
+	case stored.Duplicate:
+		return "duplicate"
+	case stored.Rejected:
+		return "rejected"
+	case stored.Record.CarriedForward:
+		return "carried forward"
+	default:
+		return "accepted"
+	}
+}
+
+func TestCreateUsageRecords(t *testing.T) {
+	currentHour := time.Now().UTC().Truncate(time.Hour)
+	previousHour := currentHour.Add(-time.Hour)
+	closedHour := currentHour.Add(-3 * time.Hour)
+	record := func(key string, hour time.Time, quantity int64) models.UsageRecord {
+		r := models.UsageRecord{
+			CustomerIdentifier: "c1",
+			ProductCode:        "p1",
+			Dimension:          "requests",
+			Quantity:           quantity,
+			UsageTimestamp:     hour.Add(time.Minute),
+		}
+		if key != "" {
+			r.IdempotencyKey = aws.String(key)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name string
+		// submitPrevious claims the bucket of the previous hour before the records are stored
+		submitPrevious bool
+		policy         LateUsagePolicy
+		records        []models.UsageRecord
+		wantOutcomes   []string
+		// wantQuantities maps the hours of pending buckets to their quantity
+		wantQuantities map[time.Time]int64
+	}{
+		{
+			name:           "records of an hour share a bucket",
+			policy:         LateUsageCarryForward,
+			records:        []models.UsageRecord{record("", previousHour, 3), record("", previousHour, 4), record("", currentHour, 5)},
+			wantOutcomes:   []string{"accepted", "accepted", "accepted"},
+			wantQuantities: map[time.Time]int64{previousHour: 7, currentHour: 5},
+		},
+		{
+			name:           "repeated idempotency key",
+			policy:         LateUsageCarryForward,
+			records:        []models.UsageRecord{record("k1", currentHour, 3), record("k1", currentHour, 3), record("k2", currentHour, 3)},
+			wantOutcomes:   []string{"accepted", "duplicate", "accepted"},
+			wantQuantities: map[time.Time]int64{currentHour: 6},
+		},
+		{
+			name:           "closed hour carried forward",
+			policy:         LateUsageCarryForward,
+			records:        []models.UsageRecord{record("", closedHour, 2)},
+			wantOutcomes:   []string{"carried forward"},
+			wantQuantities: map[time.Time]int64{currentHour: 2},
+		},
+		{
+			name:           "closed hour rejected",
+			policy:         LateUsageReject,
+			records:        []models.UsageRecord{record("", closedHour, 2)},
+			wantOutcomes:   []string{"rejected"},
+			wantQuantities: map[time.Time]int64{},
+		},
+		{
+			name:           "submitted hour carried forward",
+			submitPrevious: true,
+			policy:         LateUsageCarryForward,
+			records:        []models.UsageRecord{record("", previousHour, 2)},
+			wantOutcomes:   []string{"carried forward"},
+			wantQuantities: map[time.Time]int64{currentHour: 2},
+		},
+		{
+			name:           "submitted hour rejected",
+			submitPrevious: true,
+			policy:         LateUsageReject,
+			records:        []models.UsageRecord{record("", previousHour, 2)},
+			wantOutcomes:   []string{"rejected"},
+			wantQuantities: map[time.Time]int64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repository, database := newTestRepository(t)
+			if tt.submitPrevious {
+				if _, err := repository.CreateUsageRecords(ctx, []models.UsageRecord{record("", previousHour, 1)}, tt.policy); err != nil {
+					t.Fatal(err)
+				}
+				if claimed, err := repository.ClaimUsageBuckets(ctx, currentHour, 10); err != nil || len(claimed) != 1 {
+					t.Fatalf("ClaimUsageBuckets() = %d buckets, %v, want 1", len(claimed), err)
+				}
+			}
+
+			stored, err := repository.CreateUsageRecords(ctx, tt.records, tt.policy)
+			if err != nil {
+				t.Fatalf("CreateUsageRecords() error = %v", err)
+			}
+			if len(stored) != len(tt.wantOutcomes) {
+				t.Fatalf("stored %d records, want %d", len(stored), len(tt.wantOutcomes))
+			}
+			for i, st := range stored {
+				if got := usageOutcome(st); got != tt.wantOutcomes[i] {
+					t.Errorf("record %d is %s, want %s", i, got, tt.wantOutcomes[i])
+				}
+			}
+
+			var buckets []models.UsageBucket
+			if err := database.Where("status = ?", models.UsageBucketStatusPending).Find(&buckets).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(buckets) != len(tt.wantQuantities) {
+				t.Fatalf("got %d pending buckets, want %d", len(buckets), len(tt.wantQuantities))
+			}
+			for _, bucket := range buckets {
+				if want := tt.wantQuantities[bucket.UsageHour.UTC()]; bucket.Quantity != want {
+					t.Errorf("bucket of %s has quantity %d, want %d", bucket.UsageHour.UTC(), bucket.Quantity, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUsageBucketRetryAndDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	repository, database := newTestRepository(t)
+	currentHour := time.Now().UTC().Truncate(time.Hour)
+
+	if _, err := repository.CreateUsageRecords(ctx, []models.UsageRecord{{
+		CustomerIdentifier: "c1",
+		ProductCode:        "p1",
+		Dimension:          "requests",
+		Quantity:           3,
+		UsageTimestamp:     currentHour.Add(-time.Hour),
+	}}, LateUsageCarryForward); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := repository.ClaimUsageBuckets(ctx, currentHour, 10)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("ClaimUsageBuckets() = %d buckets, %v, want 1", len(claimed), err)
+	}
+	bucketID := claimed[0].UsageBucketID
+	if again, err := repository.ClaimUsageBuckets(ctx, currentHour, 10); err != nil || len(again) != 0 {
+		t.Fatalf("second ClaimUsageBuckets() = %d buckets, %v, want none while submitting", len(again), err)
+	}
+
+	// A retry that is not due yet keeps the bucket from being claimed
+	if err := repository.RetryUsageBuckets(ctx, []UsageBucketRetry{{
+		UsageBucketID: bucketID,
+		NextAttemptAt: time.Now().Add(time.Hour),
+		Message:       "unprocessed",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, err := repository.ClaimUsageBuckets(ctx, currentHour, 10); err != nil || len(claimed) != 0 {
+		t.Fatalf("ClaimUsageBuckets() = %d buckets, %v, want none before the next attempt", len(claimed), err)
+	}
+
+	// Once the next attempt is due the bucket is claimed again with its attempt counted
+	if err := database.Model(&models.UsageBucket{}).
+		Where("usage_bucket_id = ?", bucketID).
+		Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	claimed, err = repository.ClaimUsageBuckets(ctx, currentHour, 10)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("ClaimUsageBuckets() = %d buckets, %v, want the retried bucket", len(claimed), err)
+	}
+	if claimed[0].Attempts != 1 || claimed[0].StatusMessage != "unprocessed" {
+		t.Fatalf("retried bucket = %+v, want 1 attempt", claimed[0])
+	}
+
+	if err := repository.UpdateUsageBucketResults(ctx, []UsageBucketResult{{
+		UsageBucketID:  bucketID,
+		Status:         models.UsageBucketStatusFailed,
+		MeteringStatus: "CustomerNotSubscribed",
+		Reason:         "CustomerNotSubscribed",
+		Message:        "record rejected by AWS with status CustomerNotSubscribed",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	deadLetters, err := repository.ListUsageDeadLetters(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetters) != 1 {
+		t.Fatalf("ListUsageDeadLetters() returned %d dead letters, want 1", len(deadLetters))
+	}
+	if deadLetter := deadLetters[0]; deadLetter.UsageBucketID != bucketID || deadLetter.Quantity != 3 ||
+		deadLetter.Reason != "CustomerNotSubscribed" || deadLetter.Attempts != 2 {
+		t.Fatalf("dead letter = %+v", deadLetter)
+	}
+}
@@ -0,0 +1,129 @@
+package repo
+
+import (
+	"aws-markertplace-integration/db/models"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WebhookDeliveryResult is the outcome of a webhook delivery attempt
+type WebhookDeliveryResult struct {
+	DeliveryID    int64
+	Status        models.WebhookDeliveryStatus
+	StatusCode    int
+	Error         string
+	NextAttemptAt *time.Time
+}
+
+// CreateWebhookDeliveries queues webhook deliveries for dispatch. A delivery of an event to a URL
+// that is already queued is skipped, so that publishing an event again does not duplicate it.
+func (r *repository) CreateWebhookDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		eventIDs := make([]string, 0, len(deliveries))
+		for _, delivery := range deliveries {
+			eventIDs = append(eventIDs, delivery.EventID)
+		}
+		var queued []models.WebhookDelivery
+		if err := tx.Select("event_id", "url").Where("event_id IN ?", eventIDs).Find(&queued).Error; err != nil {
+			return err
+		}
+		exists := make(map[[2]string]bool, len(queued))
+		for _, delivery := range queued {
+			exists[[2]string{delivery.EventID, delivery.URL}] = true
+		}
+
+		now := time.Now()
+		pending := make([]models.WebhookDelivery, 0, len(deliveries))
+		for _, delivery := range deliveries {
+			if exists[[2]string{delivery.EventID, delivery.URL}] {
+				continue
+			}
+			delivery.Status = models.WebhookDeliveryStatusPending
+			delivery.CreatedAt = now
+			delivery.UpdatedAt = now
+			pending = append(pending, delivery)
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		return tx.Create(&pending).Error
+	})
+}
+
+// ClaimWebhookDeliveries marks pending deliveries that are due for an attempt as sending and
+// returns them, oldest first. Deliveries left in sending by an interrupted run are claimed again.
+func (r *repository) ClaimWebhookDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("(status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)) OR (status = ? AND updated_at < ?)",
+				models.WebhookDeliveryStatusPending,
+				now,
+				models.WebhookDeliveryStatusSending,
+				now.Add(-staleSubmissionTimeout)).
+			Order("delivery_id").
+			Limit(limit).
+			Find(&deliveries).Error; err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, 0, len(deliveries))
+		for i := range deliveries {
+			ids = append(ids, deliveries[i].DeliveryID)
+			deliveries[i].Status = models.WebhookDeliveryStatusSending
+		}
+		return tx.Model(&models.WebhookDelivery{}).
+			Where("delivery_id IN ?", ids).
+			Updates(map[string]any{
+				"status":     models.WebhookDeliveryStatusSending,
+				"updated_at": now,
+			}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// UpdateWebhookDeliveryResult records the outcome of a delivery attempt
+func (r *repository) UpdateWebhookDeliveryResult(ctx context.Context, result WebhookDeliveryResult) error {
+	now := time.Now()
+	updates := map[string]any{
+		"status":           result.Status,
+		"attempts":         gorm.Expr("attempts + 1"),
+		"last_status_code": result.StatusCode,
+		"last_error":       truncateMessage(result.Error),
+		"next_attempt_at":  result.NextAttemptAt,
+		"updated_at":       now,
+	}
+	if result.Status == models.WebhookDeliveryStatusDelivered {
+		updates["delivered_at"] = now
+	}
+	return r.db.WithContext(ctx).
+		Model(&models.WebhookDelivery{}).
+		Where("delivery_id = ?", result.DeliveryID).
+		Updates(updates).Error
+}
+
+// ListWebhookDeliveries returns the most recent webhook deliveries, optionally only those with status
+func (r *repository) ListWebhookDeliveries(ctx context.Context, status models.WebhookDeliveryStatus, limit int) ([]models.WebhookDelivery, error) {
+	query := r.db.WithContext(ctx)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var deliveries []models.WebhookDelivery
+	if err := query.Order("delivery_id DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
@@ -2,27 +2,51 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"aws-markertplace-integration/db"
+	"aws-markertplace-integration/db/migrations"
+	"aws-markertplace-integration/db/repo"
 	"aws-markertplace-integration/logging"
 	"aws-markertplace-integration/service"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"go.uber.org/zap"
 )
 
 func main() {
 	logger := logging.NewLogger("aws-markertplace-integration")
-	// dsn := os.Getenv("DB_DSN")
-	// if dsn == "" {
-	// 	logger.Fatalf("DB_DSN environment variable not set")
-	// }
-	// db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
-	// if err != nil {
-	// 	logger.Fatalf("Failed to connect to database: %v", err)
-	// }
-	// repo := repo.NewRepository(db)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(logger, os.Args[2:])
+		return
+	}
+
+	var repository repo.Repository
+	dsn := os.Getenv("DB_DSN")
+	if dsn == "" {
+		logger.Warn("DB_DSN environment variable not set, running in stateless mode: customers, entitlements, subscriptions and usage are not persisted")
+	} else {
+		database, err := db.Open(dsn)
+		if err != nil {
+			logger.Fatalf("Failed to connect to database: %v", err)
+		}
+		if os.Getenv("DB_AUTO_MIGRATE") == "true" {
+			applied, err := migrations.New(database, logger).Up(context.Background())
+			if err != nil {
+				logger.Fatalf("Failed to migrate database: %v", err)
+			}
+			logger.Infof("Applied %d database migrations", applied)
+		}
+		repository = repo.NewRepository(database)
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -30,7 +54,239 @@ func main() {
 	if err != nil {
 		logger.Errorf("Failed to initialize AWS client: %v", err)
 	}
-	s := service.New(conf, 8080, *logger)
+	// Marketplace calls use the seller role when one is set; other AWS calls keep the runtime identity
+	marketplaceConf := conf
+	if roleARN := os.Getenv("MARKETPLACE_ROLE_ARN"); roleARN != "" {
+		role := service.AssumeRole{
+			RoleARN:     roleARN,
+			ExternalID:  os.Getenv("MARKETPLACE_ROLE_EXTERNAL_ID"),
+			SessionName: os.Getenv("MARKETPLACE_ROLE_SESSION_NAME"),
+		}
+		if err := assumeRole(ctx, logger, &marketplaceConf, role); err != nil {
+			logger.Fatalf("Failed to assume MARKETPLACE_ROLE_ARN: %v", err)
+		}
+	}
+	opts := []service.Option{
+		service.WithInternalAPIKey(os.Getenv("INTERNAL_API_KEY")),
+		service.WithAdminAPIKey(os.Getenv("ADMIN_API_KEY")),
+	}
+	// A random signing key only suits stateless local runs, since links signed with it break on
+	// restarts and on other replicas
+	if secret := os.Getenv("ONBOARDING_SECRET"); secret != "" {
+		opts = append(opts, service.WithOnboardingSecret(secret))
+	} else if repository != nil {
+		logger.Fatalf("ONBOARDING_SECRET must be set when DB_DSN is set")
+	}
+	if ttl := os.Getenv("ONBOARDING_TOKEN_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			logger.Fatalf("Invalid ONBOARDING_TOKEN_TTL: %v", err)
+		}
+		opts = append(opts, service.WithOnboardingTokenTTL(d))
+	}
+	if path := os.Getenv("PRODUCT_CATALOG_FILE"); path != "" {
+		catalog, err := service.LoadProductCatalog(path)
+		if err != nil {
+			logger.Fatalf("Failed to load PRODUCT_CATALOG_FILE: %v", err)
+		}
+		opts = append(opts, service.WithProductCatalog(catalog...))
+	}
+	if path := os.Getenv("SELLERS_FILE"); path != "" {
+		sellerConfigs, err := service.LoadSellerConfigs(path)
+		if err != nil {
+			logger.Fatalf("Failed to load SELLERS_FILE: %v", err)
+		}
+		var sellers []service.Seller
+		for _, sellerConfig := range sellerConfigs {
+			sellerConf, err := loadSellerAWSConfig(ctx, logger, sellerConfig)
+			if err != nil {
+				logger.Fatalf("Failed to initialize AWS client for seller %s: %v", sellerConfig.Name, err)
+			}
+			sellers = append(sellers, service.NewSeller(sellerConfig.Name, sellerConf, sellerConfig.ProductCodes...))
+			logger.Infow("Configured seller account",
+				"seller", sellerConfig.Name,
+				"productCodes", sellerConfig.ProductCodes)
+		}
+		opts = append(opts, service.WithSellers(sellers...))
+	}
+	if topics := os.Getenv("SNS_TOPIC_ARNS"); topics != "" {
+		var arns []string
+		for _, arn := range strings.Split(topics, ",") {
+			arns = append(arns, strings.TrimSpace(arn))
+		}
+		opts = append(opts, service.WithAllowedTopicARNs(arns...))
+	}
+	if queueURL := os.Getenv("SQS_QUEUE_URL"); queueURL != "" {
+		opts = append(opts, service.WithNotificationQueue(sqs.NewFromConfig(conf), queueURL))
+	}
+	if interval := os.Getenv("USAGE_SUBMIT_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			logger.Fatalf("Invalid USAGE_SUBMIT_INTERVAL: %v", err)
+		}
+		opts = append(opts, service.WithUsageSubmitInterval(d))
+	}
+	if maxAge := os.Getenv("ENTITLEMENT_MAX_AGE"); maxAge != "" {
+		d, err := time.ParseDuration(maxAge)
+		if err != nil {
+			logger.Fatalf("Invalid ENTITLEMENT_MAX_AGE: %v", err)
+		}
+		opts = append(opts, service.WithEntitlementMaxAge(d))
+	}
+	if interval := os.Getenv("ENTITLEMENT_RECONCILE_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			logger.Fatalf("Invalid ENTITLEMENT_RECONCILE_INTERVAL: %v", err)
+		}
+		opts = append(opts, service.WithEntitlementReconcileInterval(d))
+	}
+	if interval := os.Getenv("EXPIRY_CHECK_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			logger.Fatalf("Invalid EXPIRY_CHECK_INTERVAL: %v", err)
+		}
+		opts = append(opts, service.WithExpiryCheckInterval(d))
+	}
+	if windows := os.Getenv("EXPIRY_WARNING_DAYS"); windows != "" {
+		var days []int
+		for _, field := range strings.Split(windows, ",") {
+			d, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || d <= 0 {
+				logger.Fatalf("Invalid EXPIRY_WARNING_DAYS: %s", windows)
+			}
+			days = append(days, d)
+		}
+		opts = append(opts, service.WithExpiryWarningDays(days...))
+	}
+	if urls := os.Getenv("WEBHOOK_URLS"); urls != "" {
+		secret := os.Getenv("WEBHOOK_SECRET")
+		if secret == "" {
+			logger.Fatalf("WEBHOOK_SECRET must be set when WEBHOOK_URLS is set")
+		}
+		var events []string
+		if filter := os.Getenv("WEBHOOK_EVENTS"); filter != "" {
+			for _, event := range strings.Split(filter, ",") {
+				events = append(events, strings.TrimSpace(event))
+			}
+		}
+		var endpoints []service.WebhookEndpoint
+		for _, url := range strings.Split(urls, ",") {
+			endpoints = append(endpoints, service.WebhookEndpoint{URL: strings.TrimSpace(url), Secret: secret, Events: events})
+		}
+		opts = append(opts, service.WithWebhooks(endpoints...))
+	}
+	switch policy := repo.LateUsagePolicy(os.Getenv("LATE_USAGE_POLICY")); policy {
+	case "":
+	case repo.LateUsageCarryForward, repo.LateUsageReject:
+		opts = append(opts, service.WithLateUsagePolicy(policy))
+	default:
+		logger.Fatalf("Invalid LATE_USAGE_POLICY: %s", policy)
+	}
+	s := service.New(marketplaceConf, 8080, *logger, repository, opts...)
 	s.SetupRouter()
 	s.Run(ctx)
 }
+
+// loadSellerAWSConfig loads the AWS configuration of a seller account. The region defaults to
+// AWS_DEFAULT_REGION, and the seller role is assumed with the profile or default credentials.
+func loadSellerAWSConfig(ctx context.Context, logger *zap.SugaredLogger, seller service.SellerConfig) (aws.Config, error) {
+	region := seller.Region
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	loadOpts := []func(*config.LoadOptions) error{config.WithRegion(region)}
+	if seller.Profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(seller.Profile))
+	}
+	conf, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	if seller.RoleARN != "" {
+		if err := assumeRole(ctx, logger, &conf, seller.AssumeRole); err != nil {
+			return aws.Config{}, err
+		}
+	}
+	return conf, nil
+}
+
+// assumeRole switches conf to the credentials of a role and checks that the role can be assumed
+func assumeRole(ctx context.Context, logger *zap.SugaredLogger, conf *aws.Config, role service.AssumeRole) error {
+	if err := role.Apply(conf); err != nil {
+		return err
+	}
+	refreshAt, err := role.Check(ctx, *conf)
+	if err != nil {
+		return err
+	}
+	logger.Infow("Assumed role for marketplace calls",
+		"roleArn", role.RoleARN,
+		"refreshAt", refreshAt.Format(time.RFC3339))
+	return nil
+}
+
+// runMigrate implements the migrate subcommand: migrate [up | down [steps] | status | baseline [version]]
+func runMigrate(logger *zap.SugaredLogger, args []string) {
+	dsn := os.Getenv("DB_DSN")
+	if dsn == "" {
+		logger.Fatalf("DB_DSN environment variable not set")
+	}
+	database, err := db.Open(dsn)
+	if err != nil {
+		logger.Fatalf("Failed to connect to database: %v", err)
+	}
+	migrator := migrations.New(database, logger)
+	ctx := context.Background()
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			logger.Fatalf("Failed to migrate database: %v", err)
+		}
+		logger.Infof("Applied %d migrations", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil {
+				logger.Fatalf("Invalid number of steps: %s", args[1])
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			logger.Fatalf("Failed to revert migrations: %v", err)
+		}
+		logger.Infof("Reverted %d migrations", reverted)
+	case "baseline":
+		// Databases set up before migrations existed already hold the tables of version 1
+		version := int64(1)
+		if len(args) > 1 {
+			if version, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+				logger.Fatalf("Invalid version: %s", args[1])
+			}
+		}
+		recorded, err := migrator.Baseline(ctx, version)
+		if err != nil {
+			logger.Fatalf("Failed to baseline database: %v", err)
+		}
+		logger.Infof("Recorded %d migrations as applied", recorded)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			logger.Fatalf("Failed to read migration status: %v", err)
+		}
+		for _, status := range statuses {
+			if status.AppliedAt != nil {
+				fmt.Printf("%04d %-40s applied %s\n", status.Version, status.Name, status.AppliedAt.Format(time.RFC3339))
+			} else {
+				fmt.Printf("%04d %-40s pending\n", status.Version, status.Name)
+			}
+		}
+	default:
+		logger.Fatalf("Unknown migrate command %q, expected up, down, status or baseline", command)
+	}
+}
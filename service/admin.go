@@ -0,0 +1,211 @@
+package service
+
+import (
+	"aws-markertplace-integration/db/models"
+	"aws-markertplace-integration/db/repo"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleAdminListCustomers lists and searches customers
+func (s *Service) handleAdminListCustomers(c *gin.Context) {
+	filter := repo.CustomerFilter{
+		Query:       c.Query("q"),
+		ProductCode: c.Query("product_code"),
+		Status:      models.SubscriptionStatus(c.Query("status")),
+		Country:     c.Query("country"),
+		Company:     c.Query("company"),
+		Limit:       100,
+	}
+
+	switch filter.Status {
+	case "", models.SubscriptionStatusPending, models.SubscriptionStatusActive, models.SubscriptionStatusFailed,
+		models.SubscriptionStatusPendingCancellation, models.SubscriptionStatusCancelled:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		return
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		filter.Limit = n
+	}
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+			return
+		}
+		filter.Offset = n
+	}
+
+	customers, err := s.repo.SearchCustomers(c.Request.Context(), filter)
+	if err != nil {
+		s.logger.Errorw("Failed to search customers", "error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search customers"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"customers": customers})
+}
+
+// handleAdminGetCustomer shows a customer with contact details, subscriptions and current entitlements
+func (s *Service) handleAdminGetCustomer(c *gin.Context) {
+	customerIdentifier := c.Param("customerIdentifier")
+
+	customer, err := s.repo.GetCustomerByID(c.Request.Context(), customerIdentifier)
+	if errors.Is(err, repo.ErrCustomerNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+	if err != nil {
+		s.logger.Errorw("Failed to get customer",
+			"customerIdentifier", customerIdentifier,
+			"error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get customer"})
+		return
+	}
+
+	subscriptions, err := s.repo.ListSubscriptionsByCustomerID(c.Request.Context(), customerIdentifier)
+	if err != nil {
+		s.logger.Errorw("Failed to list subscriptions",
+			"customerIdentifier", customerIdentifier,
+			"error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get customer"})
+		return
+	}
+
+	entitlements, err := s.repo.GetEntitlementsByCustomerID(c.Request.Context(), customerIdentifier)
+	if err != nil {
+		s.logger.Errorw("Failed to list entitlements",
+			"customerIdentifier", customerIdentifier,
+			"error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get customer"})
+		return
+	}
+
+	response := CustomerDetailResponse{
+		Customer:      customer,
+		Subscriptions: subscriptions,
+		Entitlements:  make([]CurrentEntitlement, 0, len(entitlements)),
+	}
+	for _, entitlement := range entitlements {
+		response.Entitlements = append(response.Entitlements, CurrentEntitlement{
+			ProductCode:    entitlement.ProductCode,
+			Dimension:      entitlement.Dimension,
+			Value:          entitlementValueResponse(entitlement.Value),
+			ExpirationDate: entitlement.ExpirationDate,
+			UpdatedAt:      entitlement.CreatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// handleAdminListProducts lists all known products
+func (s *Service) handleAdminListProducts(c *gin.Context) {
+	products, err := s.repo.ListProducts(c.Request.Context())
+	if err != nil {
+		s.logger.Errorw("Failed to list products", "error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list products"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"products": products})
+}
+
+// handleAdminUpdateProduct changes the metadata of a product
+func (s *Service) handleAdminUpdateProduct(c *gin.Context) {
+	productCode := c.Param("productCode")
+
+	var req UpdateProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload", "details": err.Error()})
+		return
+	}
+	if req.ProductName == nil && req.ProductID == nil && req.LogoURL == nil && req.SupportContact == nil && req.RedirectURL == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+	if req.ProductName != nil && strings.TrimSpace(*req.ProductName) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "product_name must not be empty"})
+		return
+	}
+	entry := ProductCatalogEntry{ProductCode: productCode}
+	if req.LogoURL != nil {
+		entry.LogoURL = *req.LogoURL
+	}
+	if req.SupportContact != nil {
+		entry.SupportContact = *req.SupportContact
+	}
+	if req.RedirectURL != nil {
+		entry.RedirectURL = *req.RedirectURL
+	}
+	if err := entry.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	product, err := s.repo.UpdateProduct(c.Request.Context(), productCode, repo.ProductUpdate{
+		ProductID:      req.ProductID,
+		ProductName:    req.ProductName,
+		LogoURL:        req.LogoURL,
+		SupportContact: req.SupportContact,
+		RedirectURL:    req.RedirectURL,
+	})
+	if errors.Is(err, repo.ErrProductNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if err != nil {
+		s.logger.Errorw("Failed to update product",
+			"productCode", productCode,
+			"error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+		return
+	}
+
+	s.logger.Infow("Product updated",
+		"productCode", productCode,
+		"remoteAddr", c.ClientIP())
+	c.JSON(http.StatusOK, product)
+}
+
+// handleAdminPutProduct creates a product or replaces its catalog details
+func (s *Service) handleAdminPutProduct(c *gin.Context) {
+	var entry ProductCatalogEntry
+	if err := c.ShouldBindJSON(&entry); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload", "details": err.Error()})
+		return
+	}
+	entry.ProductCode = c.Param("productCode")
+	if err := entry.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.repo.UpsertProducts(c.Request.Context(), []models.Product{entry.model()}); err != nil {
+		s.logger.Errorw("Failed to store product",
+			"productCode", entry.ProductCode,
+			"error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store product"})
+		return
+	}
+	product, err := s.repo.GetProduct(c.Request.Context(), entry.ProductCode)
+	if err != nil {
+		s.logger.Errorw("Failed to get product",
+			"productCode", entry.ProductCode,
+			"error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store product"})
+		return
+	}
+
+	s.logger.Infow("Product catalog entry stored",
+		"productCode", entry.ProductCode,
+		"remoteAddr", c.ClientIP())
+	c.JSON(http.StatusOK, product)
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WithInternalAPIKey sets the bearer token product backends use to call the internal API
+func WithInternalAPIKey(key string) Option {
+	return func(s *Service) {
+		s.internalAPIKey = key
+	}
+}
+
+// WithAdminAPIKey sets the bearer token operations staff use to call the admin API
+func WithAdminAPIKey(key string) Option {
+	return func(s *Service) {
+		s.adminAPIKey = key
+	}
+}
+
+// requireAPIKey rejects requests that do not carry the expected bearer token.
+// An empty key disables the protected routes altogether.
+func (s *Service) requireAPIKey(key string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if key == "" || !ok || subtle.ConstantTimeCompare([]byte(token), []byte(key)) != 1 {
+			s.logger.Warnw("Rejected unauthenticated request",
+				"path", c.FullPath(),
+				"remoteAddr", c.ClientIP())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireRepository rejects requests to routes that need a database when running stateless
+func (s *Service) requireRepository(c *gin.Context) {
+	if s.repo == nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Persistence is not configured"})
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"aws-markertplace-integration/db/models"
+	"aws-markertplace-integration/db/repo"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// ProductCatalogEntry describes how a product is presented to customers during onboarding
+type ProductCatalogEntry struct {
+	ProductCode string `json:"product_code" yaml:"product_code"`
+	Name        string `json:"name" yaml:"name"`
+	LogoURL     string `json:"logo_url" yaml:"logo_url"`
+	// SupportContact is an email address or a URL
+	SupportContact string `json:"support_contact" yaml:"support_contact"`
+	// RedirectURL is where customers continue once onboarding is complete
+	RedirectURL string `json:"redirect_url" yaml:"redirect_url"`
+}
+
+// productCatalogFile is the layout of a product catalog file
+type productCatalogFile struct {
+	Products []ProductCatalogEntry `json:"products" yaml:"products"`
+}
+
+// LoadProductCatalog reads a product catalog from a YAML (.yaml, .yml) or JSON file
+func LoadProductCatalog(path string) ([]ProductCatalogEntry, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var catalog productCatalogFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &catalog)
+	default:
+		err = json.Unmarshal(content, &catalog)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse product catalog: %w", err)
+	}
+
+	seen := make(map[string]bool, len(catalog.Products))
+	for _, entry := range catalog.Products {
+		if err := entry.validate(); err != nil {
+			return nil, err
+		}
+		if seen[entry.ProductCode] {
+			return nil, fmt.Errorf("product %s is listed more than once", entry.ProductCode)
+		}
+		seen[entry.ProductCode] = true
+	}
+	return catalog.Products, nil
+}
+
+// validate checks that an entry has a product code and well-formed contact details
+func (e ProductCatalogEntry) validate() error {
+	if e.ProductCode == "" {
+		return errors.New("product_code is required")
+	}
+	if err := validateCatalogURL("logo_url", e.LogoURL); err != nil {
+		return fmt.Errorf("product %s: %w", e.ProductCode, err)
+	}
+	if err := validateCatalogURL("redirect_url", e.RedirectURL); err != nil {
+		return fmt.Errorf("product %s: %w", e.ProductCode, err)
+	}
+	if e.SupportContact != "" && supportURL(e.SupportContact) == "" {
+		return fmt.Errorf("product %s: support_contact must be an email address or an http(s) URL", e.ProductCode)
+	}
+	return nil
+}
+
+// model converts the entry to the product it is stored as
+func (e ProductCatalogEntry) model() models.Product {
+	return models.Product{
+		ProductCode:    e.ProductCode,
+		ProductName:    e.Name,
+		LogoURL:        e.LogoURL,
+		SupportContact: e.SupportContact,
+		RedirectURL:    e.RedirectURL,
+	}
+}
+
+// validateCatalogURL accepts an empty value or an absolute http(s) URL
+func validateCatalogURL(field, value string) error {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s must be an absolute http(s) URL", field)
+	}
+	return nil
+}
+
+// supportURL returns the link to a support contact, or an empty string when it is neither an
+// email address nor an http(s) URL
+func supportURL(contact string) string {
+	if validateCatalogURL("", contact) == nil {
+		return contact
+	}
+	if address, err := mail.ParseAddress(contact); err == nil && address.Name == "" {
+		return "mailto:" + address.Address
+	}
+	return ""
+}
+
+// WithProductCatalog sets the products presented during onboarding. With a database, the catalog
+// is written to the products table at startup and can then be changed through the admin API.
+func WithProductCatalog(entries ...ProductCatalogEntry) Option {
+	return func(s *Service) {
+		s.catalog = append(s.catalog, entries...)
+	}
+}
+
+// syncProductCatalog writes the configured catalog to the database. Changes made through the
+// admin API to products in the catalog are overwritten.
+func (s *Service) syncProductCatalog(ctx context.Context) {
+	products := make([]models.Product, 0, len(s.catalog))
+	for _, entry := range s.catalog {
+		products = append(products, entry.model())
+	}
+	if err := s.repo.UpsertProducts(ctx, products); err != nil {
+		s.logger.Errorw("Failed to store product catalog", "error", err.Error())
+		return
+	}
+	s.logger.Infow("Stored product catalog", "products", len(products))
+}
+
+// product returns the catalog details of a product, from the database when there is one and
+// from the configured catalog otherwise
+func (s *Service) product(ctx context.Context, productCode string) models.Product {
+	if s.repo != nil {
+		product, err := s.repo.GetProduct(ctx, productCode)
+		if err == nil {
+			return *product
+		}
+		if !errors.Is(err, repo.ErrProductNotFound) {
+			s.logger.Errorw("Failed to get product",
+				"productCode", productCode,
+				"error", err.Error())
+		}
+	}
+	if i := slices.IndexFunc(s.catalog, func(e ProductCatalogEntry) bool { return e.ProductCode == productCode }); i >= 0 {
+		return s.catalog[i].model()
+	}
+	return models.Product{ProductCode: productCode}
+}
+
+// productPage adds the catalog details of a product to the data of a page. The templates fall
+// back to their defaults for details that are not set.
+func (s *Service) productPage(ctx context.Context, productCode string, data gin.H) gin.H {
+	product := s.product(ctx, productCode)
+	page := gin.H{
+		"productName":    product.ProductName,
+		"productLogoURL": product.LogoURL,
+		"supportContact": product.SupportContact,
+		"supportURL":     supportURL(product.SupportContact),
+		"redirectURL":    product.RedirectURL,
+	}
+	if product.ProductName == "" {
+		page["productName"] = productCode
+	}
+	for k, v := range data {
+		page[k] = v
+	}
+	return page
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const (
+	// DefaultRoleSessionName identifies the service in the CloudTrail logs of the seller account
+	DefaultRoleSessionName = "aws-marketplace-integration"
+	// roleCredentialsExpiryWindow refreshes assumed role credentials before they expire, so that
+	// in-flight marketplace calls are not signed with credentials that are about to expire
+	roleCredentialsExpiryWindow = 5 * time.Minute
+	// roleCheckTimeout bounds the startup check of an assumed role
+	roleCheckTimeout = 30 * time.Second
+)
+
+// roleSessionNamePattern is the format STS accepts for role session names
+var roleSessionNamePattern = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+// AssumeRole describes a role, usually in a seller account, that marketplace calls are made with
+type AssumeRole struct {
+	RoleARN string `json:"role_arn" yaml:"role_arn"`
+	// ExternalID is the value the trust policy of the role requires, if any
+	ExternalID  string `json:"external_id" yaml:"external_id"`
+	SessionName string `json:"session_name" yaml:"session_name"`
+}
+
+// validate checks the role ARN and the session name before anything is sent to STS
+func (r AssumeRole) validate() error {
+	parsed, err := arn.Parse(r.RoleARN)
+	if err != nil || parsed.Service != "iam" || !strings.HasPrefix(parsed.Resource, "role/") {
+		return fmt.Errorf("role_arn %q is not an IAM role ARN", r.RoleARN)
+	}
+	if r.SessionName != "" && !roleSessionNamePattern.MatchString(r.SessionName) {
+		return fmt.Errorf("session_name %q must be 2 to 64 letters, digits or +=,.@_- characters", r.SessionName)
+	}
+	if r.ExternalID != "" && (len(r.ExternalID) < 2 || len(r.ExternalID) > 1224) {
+		return fmt.Errorf("external_id must be 2 to 1224 characters")
+	}
+	return nil
+}
+
+// Apply replaces the credentials of conf with those of the role. The role is assumed with the
+// original credentials of conf, and its credentials are cached and refreshed before they expire.
+func (r AssumeRole) Apply(conf *aws.Config) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	sessionName := r.SessionName
+	if sessionName == "" {
+		sessionName = DefaultRoleSessionName
+	}
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(*conf), r.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = sessionName
+		if r.ExternalID != "" {
+			o.ExternalID = aws.String(r.ExternalID)
+		}
+	})
+	conf.Credentials = aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = roleCredentialsExpiryWindow
+		o.ExpiryWindowJitterFrac = 0.5
+	})
+	return nil
+}
+
+// Check assumes the role once, so that a role that cannot be assumed is reported at startup
+// rather than on the first marketplace call. It returns when the credentials are next refreshed.
+func (r AssumeRole) Check(ctx context.Context, conf aws.Config) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, roleCheckTimeout)
+	defer cancel()
+	credentials, err := conf.Credentials.Retrieve(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to assume role %s: %w", r.RoleARN, err)
+	}
+	return credentials.Expires, nil
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"aws-markertplace-integration/db/models"
+	"aws-markertplace-integration/db/repo"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultEntitlementMaxAge is how long a persisted entitlement is trusted before it is refreshed from AWS
+	defaultEntitlementMaxAge = time.Hour
+
+	// Sources of an entitlement check answer
+	entitlementSourceDatabase = "database"
+	entitlementSourceLive     = "live"
+)
+
+// WithEntitlementMaxAge sets how long persisted entitlements are trusted by entitlement checks
+func WithEntitlementMaxAge(maxAge time.Duration) Option {
+	return func(s *Service) {
+		if maxAge > 0 {
+			s.entitlementMaxAge = maxAge
+		}
+	}
+}
+
+// handleCurrentEntitlements lists the current value of every entitlement dimension of a customer
+func (s *Service) handleCurrentEntitlements(c *gin.Context) {
+	customerIdentifier := c.Param("customerIdentifier")
+	if !s.requireCustomer(c, customerIdentifier) {
+		return
+	}
+
+	filter := repo.EntitlementFilter{ProductCode: c.Query("product_code")}
+	entitlements, err := s.repo.ListCurrentEntitlements(c.Request.Context(), customerIdentifier, filter)
+	if err != nil {
+		s.logger.Errorw("Failed to list current entitlements",
+			"customerIdentifier", customerIdentifier,
+			"error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list entitlements"})
+		return
+	}
+
+	response := CurrentEntitlementsResponse{
+		CustomerIdentifier: customerIdentifier,
+		Entitlements:       make([]CurrentEntitlement, 0, len(entitlements)),
+	}
+	for _, entitlement := range entitlements {
+		response.Entitlements = append(response.Entitlements, CurrentEntitlement{
+			ProductCode:    entitlement.ProductCode,
+			Dimension:      entitlement.Dimension,
+			Value:          entitlementValueResponse(entitlement.Value),
+			ExpirationDate: entitlement.ExpirationDate,
+			UpdatedAt:      entitlement.CreatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// handleEntitlementHistory lists every change of a customer's entitlements, oldest first
+func (s *Service) handleEntitlementHistory(c *gin.Context) {
+	customerIdentifier := c.Param("customerIdentifier")
+	if !s.requireCustomer(c, customerIdentifier) {
+		return
+	}
+
+	filter := repo.EntitlementFilter{
+		ProductCode: c.Query("product_code"),
+		Dimension:   c.Query("dimension"),
+	}
+	versions, err := s.repo.ListEntitlementHistory(c.Request.Context(), customerIdentifier, filter)
+	if err != nil {
+		s.logger.Errorw("Failed to list entitlement history",
+			"customerIdentifier", customerIdentifier,
+			"error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list entitlement history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, EntitlementHistoryResponse{
+		CustomerIdentifier: customerIdentifier,
+		Changes:            entitlementChanges(versions),
+	})
+}
+
+// requireCustomer writes a 404 response and returns false when the customer does not exist
+func (s *Service) requireCustomer(c *gin.Context, customerIdentifier string) bool {
+	_, err := s.repo.GetCustomerByID(c.Request.Context(), customerIdentifier)
+	if errors.Is(err, repo.ErrCustomerNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return false
+	}
+	if err != nil {
+		s.logger.Errorw("Failed to get customer",
+			"customerIdentifier", customerIdentifier,
+			"error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get customer"})
+		return false
+	}
+	return true
+}
+
+// entitlementChanges turns entitlement versions, grouped by product and dimension and oldest
+// first within each group, into a chronological list of changes
+func entitlementChanges(versions []models.Entitlement) []EntitlementChange {
+	changes := make([]EntitlementChange, 0, len(versions))
+	for i, version := range versions {
+		change := EntitlementChange{
+			ProductCode:    version.ProductCode,
+			Dimension:      version.Dimension,
+			NewValue:       entitlementValueResponse(version.Value),
+			Removed:        version.Removed,
+			ExpirationDate: version.ExpirationDate,
+			ChangedAt:      version.CreatedAt,
+		}
+		if i > 0 {
+			previous := versions[i-1]
+			if previous.ProductCode == version.ProductCode && previous.Dimension == version.Dimension {
+				change.OldValue = entitlementValueResponse(previous.Value)
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].ChangedAt.Before(changes[j].ChangedAt)
+	})
+	return changes
+}
+
+// entitlementValueResponse converts a persisted entitlement value to its response form
+func entitlementValueResponse(value *models.EntitlementValue) *EntitlementValueResponse {
+	if value == nil {
+		return nil
+	}
+	return &EntitlementValueResponse{
+		Type:         value.ValueType,
+		BooleanValue: value.BooleanValue,
+		DoubleValue:  value.DoubleValue,
+		IntegerValue: value.IntegerValue,
+		StringValue:  value.StringValue,
+	}
+}
+
+// handleEntitlementCheck answers whether a customer has an entitlement dimension for a product
+func (s *Service) handleEntitlementCheck(c *gin.Context) {
+	customerIdentifier := c.Query("customer_identifier")
+	productCode := c.Query("product_code")
+	dimension := c.Query("dimension")
+	if customerIdentifier == "" || productCode == "" || dimension == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "customer_identifier, product_code and dimension are required"})
+		return
+	}
+
+	response, err := s.checkEntitlement(c.Request.Context(), customerIdentifier, productCode, dimension)
+	if err != nil {
+		s.logger.Errorw("Failed to check entitlement",
+			"customerIdentifier", customerIdentifier,
+			"productCode", productCode,
+			"dimension", dimension,
+			"error", err.Error())
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to check entitlement"})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// checkEntitlement answers from the persisted entitlement while it is fresh, and otherwise
+// from a live GetEntitlements call. Stale data is served when AWS cannot be reached.
+func (s *Service) checkEntitlement(ctx context.Context, customerIdentifier, productCode, dimension string) (*EntitlementCheckResponse, error) {
+	now := time.Now()
+	response := &EntitlementCheckResponse{
+		CustomerIdentifier: customerIdentifier,
+		ProductCode:        productCode,
+		Dimension:          dimension,
+		CheckedAt:          now,
+	}
+
+	filter := repo.EntitlementFilter{ProductCode: productCode, Dimension: dimension, IncludeRemoved: true}
+	current, err := s.repo.ListCurrentEntitlements(ctx, customerIdentifier, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load entitlement: %w", err)
+	}
+
+	var persisted *models.Entitlement
+	if len(current) > 0 {
+		persisted = &current[0]
+		if now.Sub(persisted.UpdatedAt) <= s.entitlementMaxAge {
+			response.Source = entitlementSourceDatabase
+			response.setValue(entitlementValueResponse(persisted.Value), persisted.ExpirationDate, now)
+			return response, nil
+		}
+	}
+
+	live, err := s.fetchAllEntitlements(ctx, GetEntitlementsRequest{
+		CustomerIdentifier: customerIdentifier,
+		ProductCode:        productCode,
+	}, maxEntitlementPages)
+	if err != nil {
+		if persisted == nil {
+			return nil, err
+		}
+		s.logger.Warnw("Serving stale entitlement, live refresh failed",
+			"customerIdentifier", customerIdentifier,
+			"productCode", productCode,
+			"dimension", dimension,
+			"error", err.Error())
+		response.Source = entitlementSourceDatabase
+		response.Stale = true
+		response.setValue(entitlementValueResponse(persisted.Value), persisted.ExpirationDate, now)
+		return response, nil
+	}
+
+	scope := repo.EntitlementScope{ProductCode: productCode, CustomerIdentifier: customerIdentifier}
+	if _, err := s.repo.UpdateEntitlements(ctx, scope, *live); err != nil {
+		// The live answer is still correct, it just is not cached
+		s.logger.Errorw("Failed to persist refreshed entitlements",
+			"customerIdentifier", customerIdentifier,
+			"productCode", productCode,
+			"error", err.Error())
+	}
+
+	response.Source = entitlementSourceLive
+	for _, entitlement := range live.Entitlements {
+		if entitlement.Dimension != dimension {
+			continue
+		}
+		var expiration *time.Time
+		if entitlement.ExpirationDate != nil {
+			t := time.Unix(*entitlement.ExpirationDate, 0).UTC()
+			expiration = &t
+		}
+		response.setValue(liveEntitlementValueResponse(entitlement.Value), expiration, now)
+		break
+	}
+	return response, nil
+}
+
+// setValue fills in the value and expiry of an entitlement check
+func (r *EntitlementCheckResponse) setValue(value *EntitlementValueResponse, expiration *time.Time, now time.Time) {
+	r.Value = value
+	r.ExpirationDate = expiration
+	r.Expired = expiration != nil && !now.Before(*expiration)
+	r.Entitled = value != nil && !r.Expired
+}
+
+// liveEntitlementValueResponse converts an entitlement value returned by AWS to its response form
+func liveEntitlementValueResponse(value repo.EntitlementValue) *EntitlementValueResponse {
+	switch {
+	case value.BooleanValue != nil:
+		return &EntitlementValueResponse{Type: models.ValueTypeBoolean, BooleanValue: value.BooleanValue}
+	case value.DoubleValue != nil:
+		return &EntitlementValueResponse{Type: models.ValueTypeDouble, DoubleValue: value.DoubleValue}
+	case value.IntegerValue != nil:
+		return &EntitlementValueResponse{Type: models.ValueTypeInteger, IntegerValue: value.IntegerValue}
+	case value.StringValue != nil:
+		return &EntitlementValueResponse{Type: models.ValueTypeString, StringValue: value.StringValue}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"aws-markertplace-integration/db/models"
+	"aws-markertplace-integration/db/repo"
+	"context"
+	"sort"
+	"time"
+)
+
+const (
+	// defaultExpiryCheckInterval is the default interval between entitlement expiry checks
+	defaultExpiryCheckInterval = time.Hour
+)
+
+// defaultExpiryWarningDays are the default windows, in days before expiry, that warnings are sent for
+var defaultExpiryWarningDays = []int{30, 7, 1}
+
+// WithExpiryCheckInterval sets the interval between entitlement expiry checks
+func WithExpiryCheckInterval(interval time.Duration) Option {
+	return func(s *Service) {
+		if interval > 0 {
+			s.expiryCheckInterval = interval
+		}
+	}
+}
+
+// WithExpiryWarningDays sets the windows, in days before expiry, that warnings are sent for
+func WithExpiryWarningDays(days ...int) Option {
+	return func(s *Service) {
+		windows := make([]int, 0, len(days))
+		for _, d := range days {
+			if d > 0 {
+				windows = append(windows, d)
+			}
+		}
+		if len(windows) > 0 {
+			s.expiryWarningDays = windows
+		}
+	}
+}
+
+// checkEntitlementExpiry sends warnings for entitlements entering an expiry window and
+// updates which customers are active
+func (s *Service) checkEntitlementExpiry(ctx context.Context) {
+	now := time.Now()
+	s.sendExpiryWarnings(ctx, now)
+
+	deactivated, reactivated, err := s.repo.RefreshCustomerActivity(ctx, now)
+	if err != nil {
+		s.logger.Errorw("Failed to refresh customer activity", "error", err.Error())
+	}
+	for _, customerIdentifier := range deactivated {
+		s.logger.Infow("Customer marked inactive, all entitlements expired", "customerIdentifier", customerIdentifier)
+		s.notify(ctx, Event{Type: EventCustomerInactive, CustomerIdentifier: customerIdentifier, OccurredAt: now})
+	}
+	for _, customerIdentifier := range reactivated {
+		s.logger.Infow("Customer marked active again", "customerIdentifier", customerIdentifier)
+		s.notify(ctx, Event{Type: EventCustomerActive, CustomerIdentifier: customerIdentifier, OccurredAt: now})
+	}
+}
+
+// sendExpiryWarnings sends one warning per entitlement dimension, expiry and window. Only the
+// narrowest window an entitlement is in is warned about, so that an entitlement first seen five
+// days before expiry gets the 7 day warning but not the 30 day one.
+func (s *Service) sendExpiryWarnings(ctx context.Context, now time.Time) {
+	windows := append([]int(nil), s.expiryWarningDays...)
+	sort.Ints(windows)
+	widest := windows[len(windows)-1]
+
+	entitlements, err := s.repo.ListExpiringEntitlements(ctx, now, now.AddDate(0, 0, widest))
+	if err != nil {
+		s.logger.Errorw("Failed to list expiring entitlements", "error", err.Error())
+		return
+	}
+	if len(entitlements) == 0 {
+		return
+	}
+
+	warnings, err := s.repo.ListEntitlementExpiryWarnings(ctx, now)
+	if err != nil {
+		s.logger.Errorw("Failed to list entitlement expiry warnings", "error", err.Error())
+		return
+	}
+
+	// narrowestSent holds the narrowest window already warned about per dimension and expiry, so
+	// that a new version of an entitlement with the same expiry is not warned about again
+	type warningKey struct {
+		dimension      repo.EntitlementDimension
+		expirationDate int64
+	}
+	narrowestSent := make(map[warningKey]int, len(warnings))
+	for _, warning := range warnings {
+		key := warningKey{
+			dimension: repo.EntitlementDimension{
+				CustomerIdentifier: warning.CustomerIdentifier,
+				ProductCode:        warning.ProductCode,
+				Dimension:          warning.Dimension,
+			},
+			expirationDate: warning.ExpirationDate.Unix(),
+		}
+		if sent, ok := narrowestSent[key]; !ok || warning.WindowDays < sent {
+			narrowestSent[key] = warning.WindowDays
+		}
+	}
+
+	for _, entitlement := range entitlements {
+		expiration := *entitlement.ExpirationDate
+		window := 0
+		for _, days := range windows {
+			if !expiration.After(now.AddDate(0, 0, days)) {
+				window = days
+				break
+			}
+		}
+		if window == 0 {
+			continue
+		}
+		key := warningKey{
+			dimension: repo.EntitlementDimension{
+				CustomerIdentifier: entitlement.CustomerIdentifier,
+				ProductCode:        entitlement.ProductCode,
+				Dimension:          entitlement.Dimension,
+			},
+			expirationDate: expiration.Unix(),
+		}
+		if sent, ok := narrowestSent[key]; ok && sent <= window {
+			continue
+		}
+
+		warning := &models.EntitlementExpiryWarning{
+			CustomerIdentifier: entitlement.CustomerIdentifier,
+			ProductCode:        entitlement.ProductCode,
+			Dimension:          entitlement.Dimension,
+			ExpirationDate:     expiration,
+			WindowDays:         window,
+		}
+		claimed, err := s.repo.ClaimEntitlementExpiryWarning(ctx, warning)
+		if err != nil {
+			s.logger.Errorw("Failed to record entitlement expiry warning",
+				"customerIdentifier", entitlement.CustomerIdentifier,
+				"productCode", entitlement.ProductCode,
+				"dimension", entitlement.Dimension,
+				"error", err.Error())
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		err = s.publish(ctx, Event{
+			Type:               EventEntitlementExpiring,
+			CustomerIdentifier: entitlement.CustomerIdentifier,
+			ProductCode:        entitlement.ProductCode,
+			OccurredAt:         now,
+			Data: EntitlementExpiringData{
+				Dimension:      entitlement.Dimension,
+				Value:          entitlementValueResponse(entitlement.Value),
+				ExpirationDate: expiration,
+				WindowDays:     window,
+			},
+		})
+		if err != nil {
+			s.logger.Errorw("Failed to send entitlement expiry warning",
+				"customerIdentifier", entitlement.CustomerIdentifier,
+				"productCode", entitlement.ProductCode,
+				"dimension", entitlement.Dimension,
+				"windowDays", window,
+				"error", err.Error())
+			if err := s.repo.ReleaseEntitlementExpiryWarning(ctx, warning.WarningID); err != nil {
+				s.logger.Errorw("Failed to release entitlement expiry warning",
+					"warningId", warning.WarningID,
+					"error", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"aws-markertplace-integration/db"
+	"aws-markertplace-integration/db/migrations"
+	"aws-markertplace-integration/db/repo"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.uber.org/zap"
+)
+
+// newTestRepository returns a repository backed by a migrated SQLite file
+func newTestRepository(t *testing.T) repo.Repository {
+	t.Helper()
+	database, err := db.Open("sqlite://" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrations.New(database, zap.NewNop().Sugar()).Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return repo.NewRepository(database)
+}
+
+// recordingNotifier keeps the events it receives
+type recordingNotifier struct {
+	events []Event
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, event Event) error {
+	n.events = append(n.events, event)
+	return nil
+}
+
+func TestSendExpiryWarnings(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	repository := newTestRepository(t)
+	notifier := &recordingNotifier{}
+	s := New(aws.Config{}, 0, *zap.NewNop().Sugar(), repository, WithNotifier(notifier))
+
+	steps := []struct {
+		name       string
+		seats      int64
+		expiresIn  time.Duration
+		wantWindow int
+	}{
+		{name: "entitlement within the 7 day window", seats: 10, expiresIn: 5 * 24 * time.Hour, wantWindow: 7},
+		{name: "check repeated", seats: 10, expiresIn: 5 * 24 * time.Hour},
+		{name: "new version with the same expiry", seats: 20, expiresIn: 5 * 24 * time.Hour},
+		{name: "renewed into the 30 day window", seats: 20, expiresIn: 20 * 24 * time.Hour, wantWindow: 30},
+		{name: "closer to the renewed expiry", seats: 20, expiresIn: 12 * time.Hour, wantWindow: 1},
+	}
+	for _, step := range steps {
+		expiration := now.Add(step.expiresIn).Unix()
+		if _, err := repository.UpdateEntitlements(ctx, repo.EntitlementScope{ProductCode: "p1", CustomerIdentifier: "c1"}, repo.EntitlementResponse{
+			Entitlements: []repo.Entitlement{{
+				CustomerIdentifier: "c1",
+				ProductCode:        "p1",
+				Dimension:          "seats",
+				ExpirationDate:     &expiration,
+				Value:              repo.EntitlementValue{IntegerValue: aws.Int64(step.seats)},
+			}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		notifier.events = nil
+
+		s.sendExpiryWarnings(ctx, now)
+
+		if step.wantWindow == 0 {
+			if len(notifier.events) != 0 {
+				t.Fatalf("%s: sent %d warnings, want none", step.name, len(notifier.events))
+			}
+			continue
+		}
+		if len(notifier.events) != 1 {
+			t.Fatalf("%s: sent %d warnings, want 1", step.name, len(notifier.events))
+		}
+		data := notifier.events[0].Data.(EntitlementExpiringData)
+		if data.WindowDays != step.wantWindow || data.Dimension != "seats" {
+			t.Fatalf("%s: warning = %+v, want the %d day window of seats", step.name, data, step.wantWindow)
+		}
+	}
+}
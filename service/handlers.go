@@ -1,10 +1,13 @@
 package service
 
 import (
+	"aws-markertplace-integration/db/models"
 	"aws-markertplace-integration/db/repo"
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/marketplaceentitlementservice"
@@ -13,13 +16,43 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func (s *Service) getEntitlements(c *gin.Context) (*repo.GetEntitlementsResponse, error) {
-	var getEntitlementRequest GetEntitlementsRequest
-	if val, exists := c.Get("getEntitlementRequest"); exists {
-		getEntitlementRequest = val.(GetEntitlementsRequest)
-	} else if err := c.ShouldBindJSON(&getEntitlementRequest); err != nil {
-		return nil, fmt.Errorf("invalid request payload: %w", err)
+// maxEntitlementPages bounds the number of GetEntitlements pages fetched for a single customer
+const maxEntitlementPages = 100
+
+// errEntitlementPageLimit is returned when NextToken is still set after the page limit was reached
+var errEntitlementPageLimit = errors.New("entitlement page limit reached")
+
+// fetchAllEntitlements follows NextToken until every page has been fetched, so that callers
+// never persist a truncated entitlement set
+func (s *Service) fetchAllEntitlements(ctx context.Context, getEntitlementRequest GetEntitlementsRequest, maxPages int) (*repo.GetEntitlementsResponse, error) {
+	response := &repo.GetEntitlementsResponse{}
+	for page := 1; ; page++ {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("failed to get entitlements: %w", err)
+		}
+
+		result, err := s.fetchEntitlements(ctx, getEntitlementRequest)
+		if err != nil {
+			return nil, err
+		}
+		response.Entitlements = append(response.Entitlements, result.Entitlements...)
+
+		if result.NextToken == nil || *result.NextToken == "" {
+			return response, nil
+		}
+		if page >= maxPages {
+			s.logger.Errorw("Entitlement pagination limit reached",
+				"customerIdentifier", getEntitlementRequest.CustomerIdentifier,
+				"productCode", getEntitlementRequest.ProductCode,
+				"pages", page)
+			return nil, fmt.Errorf("%w after %d pages", errEntitlementPageLimit, page)
+		}
+		getEntitlementRequest.NextToken = result.NextToken
 	}
+}
+
+// fetchEntitlements fetches a single page of GetEntitlements and converts it to the repository format
+func (s *Service) fetchEntitlements(ctx context.Context, getEntitlementRequest GetEntitlementsRequest) (*repo.GetEntitlementsResponse, error) {
 	s.logger.Infow("Processing GetEntitlements request",
 		"customerIdentifier", getEntitlementRequest.CustomerIdentifier,
 		"productCode", getEntitlementRequest.ProductCode)
@@ -44,7 +77,7 @@ func (s *Service) getEntitlements(c *gin.Context) (*repo.GetEntitlementsResponse
 	}
 
 	// Call AWS Marketplace Entitlement Service
-	result, err := s.EntitlementClient.GetEntitlements(c.Request.Context(), input)
+	result, err := s.entitlementClient(getEntitlementRequest.ProductCode).GetEntitlements(ctx, input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get entitlements: %w", err)
 	}
@@ -137,29 +170,84 @@ func (s *Service) handleMarketplaceToken(c *gin.Context) {
 		return
 	}
 
-	resolvedCustomer, err := s.MeteringClient.ResolveCustomer(c, &marketplacemetering.ResolveCustomerInput{
+	// Registration tokens can only be resolved by the seller account that lists the product, so
+	// sellers other than the default one get their own route
+	meteringClient := s.MeteringClient
+	sellerName := c.Param("seller")
+	if sellerName != "" {
+		seller, ok := s.sellers[sellerName]
+		if !ok {
+			s.logger.Errorw("Unknown seller", "seller", sellerName)
+			s.handleHTMLResponse(c, "error.tmpl", http.StatusNotFound, gin.H{"errorTitle": "Unknown Seller", "errorMessage": "Seller not found."})
+			return
+		}
+		meteringClient = seller.MeteringClient
+	}
+
+	outcome := s.resolveRegistrationToken(c.Request.Context(), meteringClient, token)
+	if outcome.err != nil {
+		s.handleError(c, outcome.err)
+	}
+	if outcome.redirect != "" {
+		redirect := outcome.redirect
+		if sellerName != "" {
+			// The onboarding link is relative to the webhook, so step out of the seller prefix
+			redirect = "../../" + redirect
+		}
+		c.Redirect(302, redirect)
+		return
+	}
+	data := outcome.data
+	if outcome.productCode != "" {
+		data = s.productPage(c.Request.Context(), outcome.productCode, data)
+	}
+	s.handleHTMLResponse(c, outcome.template, outcome.statusCode, data)
+}
+
+// resolveRegistration resolves a registration token and completes the registration of the customer
+func (s *Service) resolveRegistration(ctx context.Context, meteringClient MeteringClientInterface, token string) registrationOutcome {
+	resolvedCustomer, err := meteringClient.ResolveCustomer(ctx, &marketplacemetering.ResolveCustomerInput{
 		RegistrationToken: &token,
 	})
 
 	if err != nil {
-		s.handleError(c, fmt.Errorf("failed to resolve customer: %w", err))
-		s.handleHTMLResponse(c, "error.tmpl", http.StatusInternalServerError, gin.H{"errorTitle": "Resolve Customer Failed", "errorMessage": "Failed to resolve customer."})
-		return
+		return registrationError(fmt.Errorf("failed to resolve customer: %w", err), http.StatusInternalServerError, "Resolve Customer Failed", "Failed to resolve customer.")
 	}
 
-	// Get entitlements
-	if resolvedCustomer.CustomerIdentifier == nil {
-		s.handleError(c, fmt.Errorf("customer identifier is nil"))
-		return
+	if resolvedCustomer.CustomerIdentifier == nil || resolvedCustomer.ProductCode == nil {
+		return registrationError(fmt.Errorf("customer identifier or product code is nil"), http.StatusInternalServerError, "Resolve Customer Failed", "Failed to resolve customer.")
 	}
 
-	// err = s.repo.UpdateCustomerBasicInfo(c.Request.Context(), resolvedCustomer)
+	// The rest of the registration uses the clients of the seller that lists the product, so a
+	// token resolved by another seller is rejected rather than registered against the wrong account
+	if meteringClient != s.meteringClient(*resolvedCustomer.ProductCode) {
+		return registrationError(fmt.Errorf("product %s is not listed for the seller that resolved the token", *resolvedCustomer.ProductCode),
+			http.StatusForbidden, "Seller Mismatch", "This product is not registered for this seller.")
+	}
 
-	// if err != nil {
-	// 	s.handleError(c, err)
-	// 	s.handleHTMLResponse(c, "error.tmpl", http.StatusInternalServerError, gin.H{"errorTitle": "Update Customer Info Failed", "errorMessage": "Failed to update customer info."})
-	// 	return
-	// }
+	outcome := s.completeRegistration(ctx, resolvedCustomer)
+	outcome.productCode = *resolvedCustomer.ProductCode
+	return outcome
+}
+
+// completeRegistration records a resolved customer and their entitlements and decides where
+// the customer continues
+func (s *Service) completeRegistration(ctx context.Context, resolvedCustomer *marketplacemetering.ResolveCustomerOutput) registrationOutcome {
+	var err error
+	if s.repo != nil {
+		err = s.repo.UpdateCustomerBasicInfo(ctx, resolvedCustomer)
+
+		if err != nil {
+			return registrationError(err, http.StatusInternalServerError, "Update Customer Info Failed", "Failed to update customer info.")
+		}
+
+		err = s.advanceSubscription(ctx, *resolvedCustomer.CustomerIdentifier, *resolvedCustomer.ProductCode,
+			models.SubscriptionStatusPending, transitionReasonResolveCustomer)
+
+		if err != nil {
+			return registrationError(err, http.StatusInternalServerError, "Update Subscription Failed", "Failed to update subscription.")
+		}
+	}
 
 	getEntitlementReq := GetEntitlementsRequest{
 		CustomerIdentifier: *resolvedCustomer.CustomerIdentifier,
@@ -168,53 +256,73 @@ func (s *Service) handleMarketplaceToken(c *gin.Context) {
 	s.logger.Infow("Getting entitlements",
 		"customerIdentifier", getEntitlementReq.CustomerIdentifier,
 		"productCode", getEntitlementReq.ProductCode)
-	c.Set("getEntitlementRequest", getEntitlementReq)
-	entitlements, err := s.getEntitlements(c)
+	entitlements, err := s.fetchAllEntitlements(ctx, getEntitlementReq, maxEntitlementPages)
 
 	if err != nil {
-		s.handleError(c, fmt.Errorf("failed to get entitlements: %w", err))
-		s.handleHTMLResponse(c, "error.tmpl", http.StatusInternalServerError, gin.H{"errorTitle": "Get Entitlements Failed", "errorMessage": "Failed to get entitlements."})
-		return
+		return registrationError(fmt.Errorf("failed to get entitlements: %w", err), http.StatusInternalServerError, "Get Entitlements Failed", "Failed to get entitlements.")
 	}
 
 	if len(entitlements.Entitlements) == 0 {
 		s.logger.Infow("No entitlements found",
 			"customerIdentifier", getEntitlementReq.CustomerIdentifier,
 			"productCode", getEntitlementReq.ProductCode)
-		s.handleHTMLResponse(c, "error.tmpl", http.StatusNotFound, gin.H{"errorTitle": "No Entitlements Found", "errorMessage": "No entitlements found."})
-		return
+		return registrationOutcome{
+			template:   "error.tmpl",
+			statusCode: http.StatusNotFound,
+			data:       gin.H{"errorTitle": "No Entitlements Found", "errorMessage": "No entitlements found."},
+		}
 	}
 
-	// err = s.repo.UpdateEntitlements(c.Request.Context(), *entitlements)
+	if s.repo != nil {
+		_, err = s.repo.UpdateEntitlements(ctx, repo.EntitlementScope{
+			ProductCode:        getEntitlementReq.ProductCode,
+			CustomerIdentifier: getEntitlementReq.CustomerIdentifier,
+		}, *entitlements)
+
+		if err != nil {
+			return registrationError(err, http.StatusInternalServerError, "Update Entitlements Failed", "Failed to update entitlements.")
+		}
 
-	// if err != nil {
-	// 	s.handleError(c, err)
-	// 	s.handleHTMLResponse(c, "error.tmpl", http.StatusInternalServerError, gin.H{"errorTitle": "Update Entitlements Failed", "errorMessage": "Failed to update entitlements."})
-	// 	return
-	// }
+		err = s.advanceSubscription(ctx, getEntitlementReq.CustomerIdentifier, getEntitlementReq.ProductCode,
+			models.SubscriptionStatusActive, transitionReasonEntitlementsPresent)
 
-	// res, err := s.repo.CheckCustomerRegistration(c.Request.Context(), getEntitlementReq.CustomerIdentifier)
+		if err != nil {
+			return registrationError(err, http.StatusInternalServerError, "Update Subscription Failed", "Failed to update subscription.")
+		}
 
-	// if err != nil {
-	// 	s.handleError(c, err)
-	// 	s.handleHTMLResponse(c, "error.tmpl", http.StatusInternalServerError, gin.H{"errorTitle": "Check Customer Registration Failed", "errorMessage": "Failed to check customer registration"})
-	// 	return
-	// }
+		res, err := s.repo.CheckCustomerRegistration(ctx, getEntitlementReq.CustomerIdentifier)
 
-	// if !res.NeedsRegistration {
-	// 	s.handleHTMLResponse(c, "success.tmpl", http.StatusOK, gin.H{})
-	// 	return
-	// }
+		if err != nil {
+			return registrationError(err, http.StatusInternalServerError, "Check Customer Registration Failed", "Failed to check customer registration")
+		}
+
+		if !res.NeedsRegistration {
+			return registrationOutcome{
+				template:   "success.tmpl",
+				statusCode: http.StatusOK,
+				data:       gin.H{},
+				expiresAt:  time.Now().Add(registrationTokenValidity),
+			}
+		}
+	}
+
+	now := time.Now()
+	onboardingToken, err := s.signOnboardingToken(getEntitlementReq.CustomerIdentifier, getEntitlementReq.ProductCode, now)
+	if err != nil {
+		return registrationError(err, http.StatusInternalServerError, "Onboarding Failed", "Failed to create onboarding link.")
+	}
 
 	basePath := "zvdz/aws-marketplace-integration/v1.0/"
 	s.logger.Infow("Redirecting to onboarding", basePath)
-	s.logger.Infow("full url", basePath+"onboarding/"+getEntitlementReq.CustomerIdentifier)
-	c.Redirect(302, basePath+"onboarding/"+getEntitlementReq.CustomerIdentifier)
+	return registrationOutcome{
+		redirect:  basePath + "onboarding/" + onboardingToken,
+		expiresAt: now.Add(min(registrationTokenValidity, s.onboardingTokenTTL)),
+	}
 }
 
 // Repository errors
 var (
-	ErrCustomerNotFound = errors.New("customer not found")
+	ErrCustomerNotFound = repo.ErrCustomerNotFound
 )
 
 //respond html with status code
@@ -229,6 +337,12 @@ func (s *Service) handleHTMLResponse(
 
 // handleCustomerDetails processes POST requests to update customer details
 func (s *Service) handleCustomerDetails(c *gin.Context) {
+	claims, ok := s.onboardingClaims(c)
+	if !ok {
+		return
+	}
+	customerIdentifier := claims.CustomerIdentifier
+
 	var req CustomerDetailsRequest
 
 	if err := c.ShouldBind(&req); err != nil {
@@ -242,20 +356,23 @@ func (s *Service) handleCustomerDetails(c *gin.Context) {
 	}
 
 	s.logger.Infow("Processing customer details update",
-		"customerIdentifier", req.CustomerIdentifier)
+		"customerIdentifier", customerIdentifier)
 
-	// s.handleHTMLResponse(c, "success.tmpl", http.StatusOK, gin.H{})
-	// rws, err := s.repo.CheckCustomerRegistration(c.Request.Context(), req.CustomerIdentifier)
+	if s.repo != nil {
+		rws, err := s.repo.CheckCustomerRegistration(c.Request.Context(), customerIdentifier)
 
-	// if err != nil {
-	// 	s.handleError(c, err)
-	// 	return
-	// }
+		if err != nil {
+			s.handleError(c, err)
+			s.handleHTMLResponse(c, "error.tmpl", http.StatusInternalServerError, s.productPage(c.Request.Context(), claims.ProductCode, gin.H{"errorTitle": "Check Customer Registration Failed", "errorMessage": "Failed to check customer registration"}))
+			return
+		}
 
-	// if !rws.NeedsRegistration {
-	// 	s.handleError(c, errors.New("Customer not found or Already registered"))
-	// 	return
-	// }
+		if !rws.NeedsRegistration {
+			s.handleError(c, errors.New("customer not found or already registered"))
+			s.handleHTMLResponse(c, "error.tmpl", http.StatusConflict, s.productPage(c.Request.Context(), claims.ProductCode, gin.H{"errorTitle": "Registration Not Allowed", "errorMessage": "Customer not found or already registered."}))
+			return
+		}
+	}
 
 	// Convert request to CustomerAdditionalInfo
 	customerInfo := CustomerAdditionalInfo{
@@ -268,59 +385,69 @@ func (s *Service) handleCustomerDetails(c *gin.Context) {
 	}
 
 	// Call repository method to update customer details
-	// updateCustomerError := s.repo.UpdateCustomerAdditionalInfo(c.Request.Context(), req.CustomerIdentifier, customerInfo)
-	// if updateCustomerError != nil {
-	// 	switch err {
-	// 	case ErrCustomerNotFound:
-	// 		s.logger.Errorw("Customer not found",
-	// 			"customerIdentifier", req.CustomerIdentifier)
-	// 	default:
-	// 		s.logger.Errorw("Failed to update customer details",
-	// 			"customerIdentifier", req.CustomerIdentifier,
-	// 			"error", err.Error())
-	// 	}
-	// 	s.handleHTMLResponse(c, "error.tmpl", http.StatusInternalServerError, gin.H{"errorTitle": "Update Customer Info Failed", "errorMessage": "Failed to update customer info."})
-	// 	return
-
-	// }
+	if s.repo != nil {
+		updateCustomerError := s.repo.UpdateCustomerAdditionalInfo(c.Request.Context(), customerIdentifier, claims.ProductCode, customerInfo)
+		if updateCustomerError != nil {
+			switch {
+			case errors.Is(updateCustomerError, ErrCustomerNotFound):
+				s.logger.Errorw("Customer not found",
+					"customerIdentifier", customerIdentifier)
+			default:
+				s.logger.Errorw("Failed to update customer details",
+					"customerIdentifier", customerIdentifier,
+					"error", updateCustomerError.Error())
+			}
+			s.handleHTMLResponse(c, "error.tmpl", http.StatusInternalServerError, s.productPage(c.Request.Context(), claims.ProductCode, gin.H{"errorTitle": "Update Customer Info Failed", "errorMessage": "Failed to update customer info."}))
+			return
+		}
+	} else {
+		s.logger.Warnw("Stateless mode, customer details not persisted",
+			"customerIdentifier", customerIdentifier)
+	}
 
 	s.logger.Infow("Customer details updated successfully",
-		"customerIdentifier", customerInfo)
-	s.handleHTMLResponse(c, "success.tmpl", http.StatusOK, gin.H{})
+		"customerIdentifier", customerIdentifier)
+	s.handleHTMLResponse(c, "success.tmpl", http.StatusOK, s.productPage(c.Request.Context(), claims.ProductCode, gin.H{}))
 }
 
 // handlerForm handles GET requests to retrieve customer form
 func (s *Service) handlerForm(c *gin.Context) {
-	customerIdentifier := c.Param("customerIdentifier")
+	claims, ok := s.onboardingClaims(c)
+	if !ok {
+		return
+	}
+	customerIdentifier := claims.CustomerIdentifier
 	s.logger.Infow("Handling form request", "customerIdentifier", customerIdentifier)
-	c.HTML(http.StatusOK, "index.tmpl",
-		gin.H{
-			"productName":        "asdasd",
-			"customerIdentifier": customerIdentifier,
-		})
-	// res, err := s.repo.CheckCustomerRegistration(c.Request.Context(), customerIdentifier)
-	// if err != nil {
-	// 	s.handleError(c, err)
-	// 	return
-	// }
-	// s.logger.Infow("Customer registration status",
-	// 	"customerIdentifier", customerIdentifier,
-	// 	"needsRegistration", res.NeedsRegistration)
-
-	// if !res.NeedsRegistration && res.ProductName == "" {
-	// 	s.handleHTMLResponse(c, "error.tmpl", http.StatusNotFound, gin.H{"errorTitle": "Customer Not Found", "errorMessage": "Customer not found."})
-	// 	return
-	// }
-
-	// if !res.NeedsRegistration {
-	// 	s.handleHTMLResponse(c, "success.tmpl", http.StatusOK, gin.H{})
-	// 	return
-	// }
-
-	// c.Header("Content-Type", "text/html")
-	// c.HTML(http.StatusOK, "index.tmpl",
-	// 	gin.H{
-	// 		"productName":        res.ProductName,
-	// 		"customerIdentifier": customerIdentifier,
-	// 	})
+
+	if s.repo == nil {
+		c.HTML(http.StatusOK, "index.tmpl", s.productPage(c.Request.Context(), claims.ProductCode, gin.H{
+			"onboardingToken": c.Param("token"),
+		}))
+		return
+	}
+
+	res, err := s.repo.CheckCustomerRegistration(c.Request.Context(), customerIdentifier)
+	if err != nil {
+		s.handleError(c, err)
+		s.handleHTMLResponse(c, "error.tmpl", http.StatusInternalServerError, s.productPage(c.Request.Context(), claims.ProductCode, gin.H{"errorTitle": "Check Customer Registration Failed", "errorMessage": "Failed to check customer registration"}))
+		return
+	}
+	s.logger.Infow("Customer registration status",
+		"customerIdentifier", customerIdentifier,
+		"needsRegistration", res.NeedsRegistration)
+
+	if !res.CustomerExists {
+		s.handleHTMLResponse(c, "error.tmpl", http.StatusNotFound, s.productPage(c.Request.Context(), claims.ProductCode, gin.H{"errorTitle": "Customer Not Found", "errorMessage": "Customer not found."}))
+		return
+	}
+
+	if !res.NeedsRegistration {
+		s.handleHTMLResponse(c, "success.tmpl", http.StatusOK, s.productPage(c.Request.Context(), claims.ProductCode, gin.H{}))
+		return
+	}
+
+	c.Header("Content-Type", "text/html")
+	c.HTML(http.StatusOK, "index.tmpl", s.productPage(c.Request.Context(), claims.ProductCode, gin.H{
+		"onboardingToken": c.Param("token"),
+	}))
 }
@@ -0,0 +1,292 @@
+package service
+
+import (
+	"aws-markertplace-integration/db/models"
+	"aws-markertplace-integration/db/repo"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SNS message types delivered to HTTP(S) subscribers
+const (
+	snsTypeSubscriptionConfirmation = "SubscriptionConfirmation"
+	snsTypeNotification             = "Notification"
+	snsTypeUnsubscribeConfirmation  = "UnsubscribeConfirmation"
+)
+
+// AWS Marketplace SaaS subscription actions
+const (
+	actionSubscribeSuccess   = "subscribe-success"
+	actionSubscribeFail      = "subscribe-fail"
+	actionUnsubscribePending = "unsubscribe-pending"
+	actionUnsubscribeSuccess = "unsubscribe-success"
+	actionEntitlementUpdated = "entitlement-updated"
+)
+
+// subscriptionStatusByAction maps subscription actions to the resulting subscription state
+var subscriptionStatusByAction = map[string]models.SubscriptionStatus{
+	actionSubscribeSuccess:   models.SubscriptionStatusActive,
+	actionSubscribeFail:      models.SubscriptionStatusFailed,
+	actionUnsubscribePending: models.SubscriptionStatusPendingCancellation,
+	actionUnsubscribeSuccess: models.SubscriptionStatusCancelled,
+}
+
+var errInvalidNotification = errors.New("invalid notification")
+
+// ErrTopicNotAllowed is returned for SNS messages from a topic that is not configured. Any AWS
+// account can sign messages from its own topics, so a valid signature alone proves nothing.
+var ErrTopicNotAllowed = errors.New("SNS topic is not allowed")
+
+// WithAllowedTopicARNs sets the SNS topics subscription notifications are accepted from.
+// Without any, every notification is rejected.
+func WithAllowedTopicARNs(arns ...string) Option {
+	return func(s *Service) {
+		s.allowedTopicARNs = append(s.allowedTopicARNs, arns...)
+	}
+}
+
+// authenticateSNSMessage checks that a message comes from an allowed topic before verifying its
+// signature, so that messages from foreign topics are never confirmed or processed
+func (s *Service) authenticateSNSMessage(ctx context.Context, msg SNSMessage) error {
+	if !slices.Contains(s.allowedTopicARNs, msg.TopicArn) {
+		return fmt.Errorf("%w: %q", ErrTopicNotAllowed, msg.TopicArn)
+	}
+	return s.SNSVerifier.Verify(ctx, msg)
+}
+
+// handleSubscriptionNotification handles SNS deliveries for AWS Marketplace subscription events
+func (s *Service) handleSubscriptionNotification(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		s.logger.Errorw("Failed to read notification body", "error", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+
+	var msg SNSMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		s.logger.Errorw("Invalid SNS message", "error", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+
+	if headerType := c.GetHeader("x-amz-sns-message-type"); headerType != "" && headerType != msg.Type {
+		s.logger.Errorw("SNS message type mismatch",
+			"header", headerType,
+			"body", msg.Type)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		return
+	}
+
+	if err := s.authenticateSNSMessage(c.Request.Context(), msg); err != nil {
+		if !isPermanentNotificationError(err) {
+			// SNS does not redeliver messages rejected with a client error, so a failure to fetch
+			// the signing certificate is reported as unavailable for SNS to retry the delivery
+			s.logger.Errorw("Failed to authenticate SNS message",
+				"type", msg.Type,
+				"messageId", msg.MessageID,
+				"topicArn", msg.TopicArn,
+				"signingCertURL", msg.SigningCertURL,
+				"error", err.Error())
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to verify message signature"})
+			return
+		}
+		s.logger.Warnw("Rejected unauthenticated SNS message",
+			"type", msg.Type,
+			"messageId", msg.MessageID,
+			"topicArn", msg.TopicArn,
+			"signatureVersion", msg.SignatureVersion,
+			"signingCertURL", msg.SigningCertURL,
+			"remoteAddr", c.ClientIP(),
+			"error", err.Error())
+		if errors.Is(err, ErrTopicNotAllowed) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Topic not allowed"})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid message signature"})
+		return
+	}
+
+	if err := s.processSNSMessage(c.Request.Context(), msg); err != nil {
+		if errors.Is(err, errInvalidNotification) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// Any other failure is reported as a server error so that SNS retries the delivery
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process notification"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// processSNSMessage dispatches an SNS message according to its type
+func (s *Service) processSNSMessage(ctx context.Context, msg SNSMessage) error {
+	switch msg.Type {
+	case snsTypeSubscriptionConfirmation:
+		return s.confirmSNSSubscription(ctx, msg)
+	case snsTypeUnsubscribeConfirmation:
+		s.logger.Infow("SNS subscription removed",
+			"topicArn", msg.TopicArn,
+			"messageId", msg.MessageID)
+		return nil
+	case snsTypeNotification:
+		var notification MarketplaceNotification
+		if err := json.Unmarshal([]byte(msg.Message), &notification); err != nil {
+			s.logger.Errorw("Invalid marketplace notification",
+				"messageId", msg.MessageID,
+				"error", err.Error())
+			return fmt.Errorf("%w: %v", errInvalidNotification, err)
+		}
+		return s.handleMarketplaceNotification(ctx, notification)
+	default:
+		s.logger.Errorw("Unsupported SNS message type",
+			"type", msg.Type,
+			"messageId", msg.MessageID)
+		return fmt.Errorf("%w: unsupported message type %q", errInvalidNotification, msg.Type)
+	}
+}
+
+// confirmSNSSubscription visits the SubscribeURL to confirm the SNS topic subscription
+func (s *Service) confirmSNSSubscription(ctx context.Context, msg SNSMessage) error {
+	subscribeURL, err := url.Parse(msg.SubscribeURL)
+	if err != nil || subscribeURL.Scheme != "https" || !strings.HasSuffix(subscribeURL.Hostname(), ".amazonaws.com") {
+		s.logger.Errorw("Invalid SubscribeURL", "subscribeURL", msg.SubscribeURL)
+		return fmt.Errorf("%w: invalid SubscribeURL", errInvalidNotification)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, subscribeURL.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to build subscription confirmation request: %w", err)
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		s.logger.Errorw("Failed to confirm SNS subscription",
+			"topicArn", msg.TopicArn,
+			"error", err.Error())
+		return fmt.Errorf("failed to confirm subscription: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		s.logger.Errorw("SNS subscription confirmation rejected",
+			"topicArn", msg.TopicArn,
+			"status", resp.StatusCode)
+		return fmt.Errorf("failed to confirm subscription: unexpected status %d", resp.StatusCode)
+	}
+
+	s.logger.Infow("SNS subscription confirmed", "topicArn", msg.TopicArn)
+	return nil
+}
+
+// handleMarketplaceNotification applies a subscription event to the customer's subscription state
+func (s *Service) handleMarketplaceNotification(ctx context.Context, notification MarketplaceNotification) error {
+	if notification.CustomerIdentifier == "" || notification.ProductCode == "" {
+		s.logger.Errorw("Marketplace notification is missing required fields",
+			"action", notification.Action)
+		return fmt.Errorf("%w: missing customer identifier or product code", errInvalidNotification)
+	}
+
+	s.logger.Infow("Processing marketplace notification",
+		"action", notification.Action,
+		"customerIdentifier", notification.CustomerIdentifier,
+		"productCode", notification.ProductCode,
+		"offerIdentifier", notification.OfferIdentifier)
+
+	if notification.Action == actionEntitlementUpdated {
+		return s.syncEntitlements(ctx, notification.CustomerIdentifier, notification.ProductCode)
+	}
+
+	status, ok := subscriptionStatusByAction[notification.Action]
+	if !ok {
+		// Unknown actions are acknowledged so that they are not redelivered forever
+		s.logger.Warnw("Ignoring unsupported marketplace action", "action", notification.Action)
+		return nil
+	}
+
+	if s.repo == nil {
+		s.logger.Warnw("No repository configured, subscription state not persisted",
+			"customerIdentifier", notification.CustomerIdentifier,
+			"status", status)
+		return nil
+	}
+
+	changed, err := s.repo.TransitionSubscription(ctx, notification.CustomerIdentifier, notification.ProductCode, status, notification.Action)
+	if errors.Is(err, repo.ErrInvalidSubscriptionTransition) {
+		// Redelivering the notification would not make the transition valid, so it is acknowledged
+		s.logger.Warnw("Rejected subscription transition",
+			"customerIdentifier", notification.CustomerIdentifier,
+			"productCode", notification.ProductCode,
+			"action", notification.Action,
+			"error", err.Error())
+		return nil
+	}
+	if err != nil {
+		s.logger.Errorw("Failed to update subscription status",
+			"customerIdentifier", notification.CustomerIdentifier,
+			"productCode", notification.ProductCode,
+			"status", status,
+			"error", err.Error())
+		return fmt.Errorf("failed to update subscription status: %w", err)
+	}
+
+	s.logger.Infow("Subscription status updated",
+		"customerIdentifier", notification.CustomerIdentifier,
+		"productCode", notification.ProductCode,
+		"status", status,
+		"changed", changed)
+	return nil
+}
+
+// syncEntitlements fetches the current entitlements of a customer from AWS and persists them
+func (s *Service) syncEntitlements(ctx context.Context, customerIdentifier, productCode string) error {
+	entitlements, err := s.fetchAllEntitlements(ctx, GetEntitlementsRequest{
+		CustomerIdentifier: customerIdentifier,
+		ProductCode:        productCode,
+	}, maxEntitlementPages)
+	if err != nil {
+		s.logger.Errorw("Failed to re-sync entitlements",
+			"customerIdentifier", customerIdentifier,
+			"productCode", productCode,
+			"error", err.Error())
+		return err
+	}
+
+	if s.repo == nil {
+		s.logger.Warnw("No repository configured, entitlements not persisted",
+			"customerIdentifier", customerIdentifier,
+			"productCode", productCode,
+			"count", len(entitlements.Entitlements))
+		return nil
+	}
+
+	changes, err := s.repo.UpdateEntitlements(ctx, repo.EntitlementScope{
+		ProductCode:        productCode,
+		CustomerIdentifier: customerIdentifier,
+	}, *entitlements)
+	if err != nil {
+		s.logger.Errorw("Failed to update entitlements",
+			"customerIdentifier", customerIdentifier,
+			"productCode", productCode,
+			"error", err.Error())
+		return fmt.Errorf("failed to update entitlements: %w", err)
+	}
+
+	s.logger.Infow("Entitlements re-synced",
+		"customerIdentifier", customerIdentifier,
+		"productCode", productCode,
+		"count", len(entitlements.Entitlements),
+		"created", len(changes.Created),
+		"changed", len(changes.Changed),
+		"removed", len(changes.Removed))
+	return nil
+}
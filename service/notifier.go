@@ -0,0 +1,98 @@
+package service
+
+import (
+	"aws-markertplace-integration/db/models"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Event types emitted to the Notifier
+const (
+	EventCustomerCreated       = models.EventTypeCustomerCreated
+	EventCustomerRegistered    = models.EventTypeCustomerRegistered
+	EventEntitlementChanged    = models.EventTypeEntitlementChanged
+	EventSubscriptionCancelled = models.EventTypeSubscriptionCancelled
+	EventEntitlementExpiring   = "entitlement.expiring"
+	EventCustomerInactive      = "customer.inactive"
+	EventCustomerActive        = "customer.active"
+)
+
+// Event describes something that happened to a customer. Data holds the type-specific payload.
+type Event struct {
+	ID                 string    `json:"id"`
+	Type               string    `json:"type"`
+	CustomerIdentifier string    `json:"customer_identifier"`
+	ProductCode        string    `json:"product_code,omitempty"`
+	OccurredAt         time.Time `json:"occurred_at"`
+	Data               any       `json:"data,omitempty"`
+}
+
+// EntitlementExpiringData is the payload of an entitlement.expiring event
+type EntitlementExpiringData struct {
+	Dimension      string                    `json:"dimension"`
+	Value          *EntitlementValueResponse `json:"value"`
+	ExpirationDate time.Time                 `json:"expiration_date"`
+	WindowDays     int                       `json:"window_days"`
+}
+
+// Notifier is the outbound hook that receives events emitted by the service
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// WithNotifier sets the hook that receives events emitted by the service
+func WithNotifier(notifier Notifier) Option {
+	return func(s *Service) {
+		if notifier != nil {
+			s.notifier = notifier
+		}
+	}
+}
+
+// logNotifier is the default Notifier, which only logs events
+type logNotifier struct {
+	logger *zap.SugaredLogger
+}
+
+// Notify logs the event
+func (n logNotifier) Notify(ctx context.Context, event Event) error {
+	n.logger.Infow("Event emitted",
+		"type", event.Type,
+		"customerIdentifier", event.CustomerIdentifier,
+		"productCode", event.ProductCode,
+		"data", event.Data)
+	return nil
+}
+
+// publish hands an event to the notifier and queues it for the configured webhooks
+func (s *Service) publish(ctx context.Context, event Event) error {
+	if event.ID == "" {
+		event.ID = newEventID()
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+	return errors.Join(s.notifier.Notify(ctx, event), s.queueWebhooks(ctx, event))
+}
+
+// notify publishes an event, logging failures
+func (s *Service) notify(ctx context.Context, event Event) {
+	if err := s.publish(ctx, event); err != nil {
+		s.logger.Errorw("Failed to send event",
+			"type", event.Type,
+			"customerIdentifier", event.CustomerIdentifier,
+			"error", err.Error())
+	}
+}
+
+// newEventID returns a random identifier that lets receivers deduplicate events
+func newEventID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultOnboardingTokenTTL is how long an onboarding link stays valid
+const defaultOnboardingTokenTTL = time.Hour
+
+var (
+	errInvalidOnboardingToken = errors.New("invalid onboarding token")
+	errExpiredOnboardingToken = errors.New("onboarding token expired")
+)
+
+// onboardingClaims binds an onboarding link to the customer and product it was issued for
+type onboardingClaims struct {
+	CustomerIdentifier string `json:"c"`
+	ProductCode        string `json:"p"`
+	ExpiresAt          int64  `json:"exp"`
+}
+
+// WithOnboardingSecret sets the key that signs onboarding links. Every replica must use the same key.
+func WithOnboardingSecret(secret string) Option {
+	return func(s *Service) {
+		s.onboardingSecret = []byte(secret)
+	}
+}
+
+// WithOnboardingTokenTTL sets how long an onboarding link stays valid
+func WithOnboardingTokenTTL(ttl time.Duration) Option {
+	return func(s *Service) {
+		if ttl > 0 {
+			s.onboardingTokenTTL = ttl
+		}
+	}
+}
+
+// ensureOnboardingSecret generates a random signing key when none is configured. Links signed
+// with it only work on this replica and stop working when it restarts, so it is only meant for
+// stateless local runs.
+func (s *Service) ensureOnboardingSecret() {
+	if len(s.onboardingSecret) > 0 {
+		return
+	}
+	s.logger.Warn("No onboarding secret configured, using a random key: onboarding links do not survive restarts and only work on this replica")
+	s.onboardingSecret = make([]byte, 32)
+	_, _ = rand.Read(s.onboardingSecret)
+}
+
+// signOnboardingToken issues a token of the form <payload>.<signature>, both base64url encoded,
+// where the signature is the HMAC-SHA256 of the encoded payload
+func (s *Service) signOnboardingToken(customerIdentifier, productCode string, now time.Time) (string, error) {
+	payload, err := json.Marshal(onboardingClaims{
+		CustomerIdentifier: customerIdentifier,
+		ProductCode:        productCode,
+		ExpiresAt:          now.Add(s.onboardingTokenTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.onboardingSignature(encoded)), nil
+}
+
+// verifyOnboardingToken checks the signature and expiry of a token and returns its claims
+func (s *Service) verifyOnboardingToken(token string, now time.Time) (*onboardingClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errInvalidOnboardingToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.onboardingSignature(encoded)) {
+		return nil, errInvalidOnboardingToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidOnboardingToken
+	}
+
+	var claims onboardingClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.CustomerIdentifier == "" {
+		return nil, errInvalidOnboardingToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, errExpiredOnboardingToken
+	}
+	return &claims, nil
+}
+
+// onboardingSignature computes the HMAC-SHA256 of an encoded token payload
+func (s *Service) onboardingSignature(encoded string) []byte {
+	mac := hmac.New(sha256.New, s.onboardingSecret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// onboardingClaims validates the token of an onboarding route. It renders an error page and
+// returns false when the link is invalid or expired.
+func (s *Service) onboardingClaims(c *gin.Context) (*onboardingClaims, bool) {
+	claims, err := s.verifyOnboardingToken(c.Param("token"), time.Now())
+	switch {
+	case errors.Is(err, errExpiredOnboardingToken):
+		s.logger.Warnw("Rejected expired onboarding link", "remoteAddr", c.ClientIP())
+		s.handleHTMLResponse(c, "error.tmpl", http.StatusForbidden, gin.H{"errorTitle": "Link Expired", "errorMessage": "This onboarding link has expired. Please open the product again from AWS Marketplace."})
+		return nil, false
+	case err != nil:
+		s.logger.Warnw("Rejected invalid onboarding link", "remoteAddr", c.ClientIP())
+		s.handleHTMLResponse(c, "error.tmpl", http.StatusForbidden, gin.H{"errorTitle": "Invalid Link", "errorMessage": "This onboarding link is not valid."})
+		return nil, false
+	}
+	return claims, true
+}
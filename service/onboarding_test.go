@@ -0,0 +1,63 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.uber.org/zap"
+)
+
+func TestVerifyOnboardingToken(t *testing.T) {
+	now := time.Now()
+	s := New(aws.Config{}, 0, *zap.NewNop().Sugar(), nil, WithOnboardingSecret("test-secret"), WithOnboardingTokenTTL(time.Hour))
+	other := New(aws.Config{}, 0, *zap.NewNop().Sugar(), nil, WithOnboardingSecret("other-secret"))
+
+	token, err := s.signOnboardingToken("c1", "p1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, signature, _ := strings.Cut(token, ".")
+	otherToken, err := other.signOnboardingToken("c1", "p1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sign signs payload with the key of s, so that only the claims are invalid
+	sign := func(payload string) string {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+		return encoded + "." + base64.RawURLEncoding.EncodeToString(s.onboardingSignature(encoded))
+	}
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"c":"c2","p":"p1","exp":9999999999}`))
+
+	tests := []struct {
+		name    string
+		token   string
+		at      time.Time
+		wantErr error
+	}{
+		{name: "valid", token: token, at: now},
+		{name: "just before expiry", token: token, at: now.Add(time.Hour - time.Second)},
+		{name: "expired", token: token, at: now.Add(time.Hour), wantErr: errExpiredOnboardingToken},
+		{name: "raw customer identifier", token: "c1", at: now, wantErr: errInvalidOnboardingToken},
+		{name: "forged payload", token: forged + "." + signature, at: now, wantErr: errInvalidOnboardingToken},
+		{name: "truncated signature", token: payload + "." + signature[:len(signature)-2], at: now, wantErr: errInvalidOnboardingToken},
+		{name: "signed with another key", token: otherToken, at: now, wantErr: errInvalidOnboardingToken},
+		{name: "signature is not base64", token: payload + ".!!", at: now, wantErr: errInvalidOnboardingToken},
+		{name: "payload is not JSON", token: sign("not json"), at: now, wantErr: errInvalidOnboardingToken},
+		{name: "payload without customer", token: sign(`{"p":"p1","exp":9999999999}`), at: now, wantErr: errInvalidOnboardingToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := s.verifyOnboardingToken(tt.token, tt.at)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("verifyOnboardingToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (claims.CustomerIdentifier != "c1" || claims.ProductCode != "p1") {
+				t.Fatalf("claims = %+v, want c1 and p1", claims)
+			}
+		})
+	}
+}
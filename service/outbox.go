@@ -0,0 +1,115 @@
+package service
+
+import (
+	"aws-markertplace-integration/db/models"
+	"aws-markertplace-integration/db/repo"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const (
+	// outboxDispatchInterval is the interval between outbox dispatch runs
+	outboxDispatchInterval = 2 * time.Second
+	// outboxDispatchLimit bounds the number of events published per run
+	outboxDispatchLimit = 100
+	// maxOutboxAttempts is the number of attempts after which an event is given up
+	maxOutboxAttempts = 20
+	// outboxRetryBaseDelay and outboxRetryMaxDelay bound the backoff between publish attempts
+	outboxRetryBaseDelay = 5 * time.Second
+	outboxRetryMaxDelay  = 10 * time.Minute
+)
+
+// dispatchOutbox publishes the events that the repository wrote to the outbox. An event is only
+// marked as published once the notifier and the webhook queue have accepted it, so an event is
+// published at least once and may be published again after a crash.
+func (s *Service) dispatchOutbox(ctx context.Context) {
+	events, err := s.repo.ClaimOutboxEvents(ctx, outboxDispatchLimit)
+	if err != nil {
+		s.logger.Errorw("Failed to claim outbox events", "error", err.Error())
+		return
+	}
+
+	for _, event := range events {
+		result := s.publishOutboxEventResult(ctx, event)
+
+		// Use a fresh context so that events published during shutdown are recorded too
+		saveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := s.repo.UpdateOutboxEventResult(saveCtx, result)
+		cancel()
+		if err != nil {
+			s.logger.Errorw("Failed to record outbox event result",
+				"eventId", event.EventID,
+				"error", err.Error())
+		}
+	}
+}
+
+// publishOutboxEventResult publishes an event and decides whether a failed event is retried
+func (s *Service) publishOutboxEventResult(ctx context.Context, event models.OutboxEvent) repo.OutboxEventResult {
+	result := repo.OutboxEventResult{OutboxID: event.OutboxID, Status: models.OutboxEventStatusPublished}
+	err := s.publishOutboxEvent(ctx, event)
+	if err == nil {
+		return result
+	}
+
+	result.Error = err.Error()
+	attempts := event.Attempts + 1
+	if attempts >= maxOutboxAttempts {
+		result.Status = models.OutboxEventStatusFailed
+		s.logger.Errorw("Outbox event failed permanently",
+			"eventId", event.EventID,
+			"type", event.EventType,
+			"attempts", attempts,
+			"error", result.Error)
+		return result
+	}
+
+	result.Status = models.OutboxEventStatusPending
+	result.RetryAt = time.Now().Add(outboxRetryBackoff(attempts))
+	s.logger.Warnw("Failed to publish outbox event, will retry",
+		"eventId", event.EventID,
+		"type", event.EventType,
+		"attempts", attempts,
+		"retryAt", result.RetryAt,
+		"error", result.Error)
+	return result
+}
+
+// publishOutboxEvent publishes a single outbox event with its typed data
+func (s *Service) publishOutboxEvent(ctx context.Context, event models.OutboxEvent) error {
+	var data any
+	switch event.EventType {
+	case models.EventTypeCustomerCreated:
+		data = &models.CustomerCreatedEventData{}
+	case models.EventTypeCustomerRegistered:
+		data = &models.CustomerRegisteredEventData{}
+	case models.EventTypeEntitlementChanged:
+		data = &models.EntitlementChangedEventData{}
+	case models.EventTypeSubscriptionCancelled:
+		data = &models.SubscriptionCancelledEventData{}
+	default:
+		data = &json.RawMessage{}
+	}
+	if err := json.Unmarshal([]byte(event.Data), data); err != nil {
+		return err
+	}
+
+	return s.publish(ctx, Event{
+		ID:                 event.EventID,
+		Type:               event.EventType,
+		CustomerIdentifier: event.CustomerIdentifier,
+		ProductCode:        event.ProductCode,
+		OccurredAt:         event.CreatedAt,
+		Data:               data,
+	})
+}
+
+// outboxRetryBackoff returns the delay before the next publish attempt of an event
+func outboxRetryBackoff(attempts int) time.Duration {
+	backoff := outboxRetryBaseDelay << min(attempts-1, 10)
+	if backoff > outboxRetryMaxDelay {
+		backoff = outboxRetryMaxDelay
+	}
+	return backoff
+}
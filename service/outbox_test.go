@@ -0,0 +1,62 @@
+package service
+
+import (
+	"aws-markertplace-integration/db/models"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.uber.org/zap"
+)
+
+// failingNotifier rejects every event
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(ctx context.Context, event Event) error {
+	return errors.New("hook unavailable")
+}
+
+func TestPublishOutboxEventResult(t *testing.T) {
+	event := models.OutboxEvent{
+		OutboxID:           1,
+		EventID:            "event-1",
+		EventType:          models.EventTypeSubscriptionCancelled,
+		CustomerIdentifier: "c1",
+		ProductCode:        "p1",
+		Data:               `{"action":"unsubscribe-success"}`,
+	}
+
+	tests := []struct {
+		name       string
+		notifier   Notifier
+		attempts   int
+		data       string
+		wantStatus models.OutboxEventStatus
+	}{
+		{name: "published", notifier: &recordingNotifier{}, wantStatus: models.OutboxEventStatusPublished},
+		{name: "first failure is retried", notifier: failingNotifier{}, wantStatus: models.OutboxEventStatusPending},
+		{name: "failure before the last attempt is retried", notifier: failingNotifier{}, attempts: maxOutboxAttempts - 2, wantStatus: models.OutboxEventStatusPending},
+		{name: "last attempt fails the event", notifier: failingNotifier{}, attempts: maxOutboxAttempts - 1, wantStatus: models.OutboxEventStatusFailed},
+		{name: "undecodable data", notifier: &recordingNotifier{}, data: "{", wantStatus: models.OutboxEventStatusPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(aws.Config{}, 0, *zap.NewNop().Sugar(), nil, WithNotifier(tt.notifier))
+			event := event
+			event.Attempts = tt.attempts
+			if tt.data != "" {
+				event.Data = tt.data
+			}
+
+			result := s.publishOutboxEventResult(context.Background(), event)
+
+			if result.Status != tt.wantStatus {
+				t.Fatalf("status = %s, want %s (error %q)", result.Status, tt.wantStatus, result.Error)
+			}
+			if (result.Status == models.OutboxEventStatusPending) == result.RetryAt.IsZero() {
+				t.Fatalf("RetryAt = %v for status %s", result.RetryAt, result.Status)
+			}
+		})
+	}
+}
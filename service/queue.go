@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// queueWaitTimeSeconds is the long-polling wait time of a ReceiveMessage call
+	queueWaitTimeSeconds = 20
+	// queueMaxMessages is the maximum number of messages received per call
+	queueMaxMessages = 10
+	// queueRetryVisibilityTimeout is how long a failed message stays hidden before redelivery, in seconds
+	queueRetryVisibilityTimeout = 30
+	// queueErrorBackoff is the pause after a failed ReceiveMessage call
+	queueErrorBackoff = 5 * time.Second
+)
+
+// notificationQueue holds the SQS queue subscribed to the Marketplace SNS topics
+type notificationQueue struct {
+	client QueueClientInterface
+	url    string
+}
+
+// WithNotificationQueue enables the SQS consumer for subscription notifications
+func WithNotificationQueue(client QueueClientInterface, queueURL string) Option {
+	return func(s *Service) {
+		s.queue = &notificationQueue{client: client, url: queueURL}
+	}
+}
+
+// consumeNotificationQueue long-polls the notification queue until ctx is cancelled
+func (s *Service) consumeNotificationQueue(ctx context.Context) {
+	s.logger.Infow("Started notification queue consumer", "queueURL", s.queue.url)
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Notification queue consumer stopped")
+			return
+		default:
+		}
+
+		out, err := s.queue.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(s.queue.url),
+			MaxNumberOfMessages: queueMaxMessages,
+			WaitTimeSeconds:     queueWaitTimeSeconds,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			s.logger.Errorw("Failed to receive messages from notification queue",
+				"queueURL", s.queue.url,
+				"error", err.Error())
+			select {
+			case <-ctx.Done():
+			case <-time.After(queueErrorBackoff):
+			}
+			continue
+		}
+
+		for _, message := range out.Messages {
+			s.handleQueueMessage(ctx, message)
+		}
+	}
+}
+
+// permanentNotificationErrors fail the same way on every delivery of a message
+var permanentNotificationErrors = []error{
+	errInvalidNotification,
+	ErrTopicNotAllowed,
+	ErrInvalidSignature,
+	ErrInvalidSigningCertURL,
+	ErrUnsupportedSignatureVersion,
+	ErrCertificateExpired,
+}
+
+// handleQueueMessage processes a single queue message, deleting it on success and
+// returning it to the queue for redelivery on a transient failure. Messages that can never
+// succeed are deleted too, so that they are not redelivered forever.
+func (s *Service) handleQueueMessage(ctx context.Context, message types.Message) {
+	messageID := aws.ToString(message.MessageId)
+	if err := s.processQueueMessage(ctx, aws.ToString(message.Body)); err != nil {
+		if !isPermanentNotificationError(err) {
+			s.logger.Errorw("Failed to process queued notification",
+				"messageId", messageID,
+				"error", err.Error())
+			s.releaseQueueMessage(message)
+			return
+		}
+		s.logger.Errorw("Dropping queued notification that cannot be processed",
+			"messageId", messageID,
+			"error", err.Error())
+	}
+
+	if _, err := s.queue.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(s.queue.url),
+		ReceiptHandle: message.ReceiptHandle,
+	}); err != nil {
+		s.logger.Errorw("Failed to delete message from notification queue",
+			"messageId", messageID,
+			"error", err.Error())
+	}
+}
+
+// releaseQueueMessage returns a message to the queue so that it is redelivered after a delay
+func (s *Service) releaseQueueMessage(message types.Message) {
+	// Use a fresh context so that in-flight messages are released during shutdown too
+	releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.queue.client.ChangeMessageVisibility(releaseCtx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(s.queue.url),
+		ReceiptHandle:     message.ReceiptHandle,
+		VisibilityTimeout: queueRetryVisibilityTimeout,
+	}); err != nil {
+		s.logger.Errorw("Failed to return message to notification queue",
+			"messageId", aws.ToString(message.MessageId),
+			"error", err.Error())
+	}
+}
+
+// isPermanentNotificationError reports whether redelivering a message would fail again
+func isPermanentNotificationError(err error) bool {
+	for _, permanent := range permanentNotificationErrors {
+		if errors.Is(err, permanent) {
+			return true
+		}
+	}
+	return false
+}
+
+// processQueueMessage decodes the SNS envelope of a queue message and authenticates it like
+// the HTTP endpoint does. Raw message delivery must be disabled on the subscription, since a
+// raw message carries no signature.
+func (s *Service) processQueueMessage(ctx context.Context, body string) error {
+	var msg SNSMessage
+	if err := json.Unmarshal([]byte(body), &msg); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidNotification, err)
+	}
+	if msg.Type == "" {
+		return fmt.Errorf("%w: message is not an SNS envelope", errInvalidNotification)
+	}
+	if err := s.authenticateSNSMessage(ctx, msg); err != nil {
+		s.logger.Warnw("Rejected unauthenticated SNS message from notification queue",
+			"type", msg.Type,
+			"messageId", msg.MessageID,
+			"topicArn", msg.TopicArn,
+			"signingCertURL", msg.SigningCertURL,
+			"error", err.Error())
+		return err
+	}
+	return s.processSNSMessage(ctx, msg)
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/marketplaceentitlementservice"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"go.uber.org/zap"
+)
+
+// fakeQueueClient records what happens to the messages handed to the consumer
+type fakeQueueClient struct {
+	deleted  int
+	released int
+}
+
+func (f *fakeQueueClient) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	return &sqs.ReceiveMessageOutput{}, nil
+}
+
+func (f *fakeQueueClient) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	f.deleted++
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeQueueClient) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.released++
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// failingEntitlementClient fails every call, like an AWS outage would
+type failingEntitlementClient struct{}
+
+func (failingEntitlementClient) GetEntitlements(ctx context.Context, params *marketplaceentitlementservice.GetEntitlementsInput, optFns ...func(*marketplaceentitlementservice.Options)) (*marketplaceentitlementservice.GetEntitlementsOutput, error) {
+	return nil, errors.New("service unavailable")
+}
+
+func TestHandleQueueMessage(t *testing.T) {
+	signer := newSNSTestSigner(t)
+	envelope := func(msg SNSMessage) string {
+		body, err := json.Marshal(signer.sign(t, msg, "2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	entitlementUpdated := testNotification()
+	entitlementUpdated.Message = `{"action":"entitlement-updated","customer-identifier":"c1","product-code":"p1"}`
+	forged := testNotification()
+	forged.TopicArn = "arn:aws:sns:us-east-1:999999999999:forged"
+	forgedConfirmation := forged
+	forgedConfirmation.Type = snsTypeSubscriptionConfirmation
+	forgedConfirmation.Token = "token"
+	forgedConfirmation.SubscribeURL = "https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription"
+	tampered := signer.sign(t, testNotification(), "2")
+	tampered.Message = `{"action":"unsubscribe-success","customer-identifier":"c2","product-code":"p1"}`
+	tamperedBody, err := json.Marshal(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		body         string
+		wantDeleted  int
+		wantReleased int
+	}{
+		{name: "processed", body: envelope(testNotification()), wantDeleted: 1},
+		{name: "malformed JSON is dropped", body: "{not json", wantDeleted: 1},
+		{name: "raw notification is dropped", body: `{"action":"unsubscribe-success","customer-identifier":"c1","product-code":"p1"}`, wantDeleted: 1},
+		{name: "undecodable notification is dropped", body: envelope(SNSMessage{Type: snsTypeNotification, MessageID: "m", TopicArn: testTopicARN, Message: "{", Timestamp: "t"}), wantDeleted: 1},
+		{name: "message from another topic is dropped", body: envelope(forged), wantDeleted: 1},
+		{name: "confirmation from another topic is dropped", body: envelope(forgedConfirmation), wantDeleted: 1},
+		{name: "tampered message is dropped", body: string(tamperedBody), wantDeleted: 1},
+		{name: "transient failure is redelivered", body: envelope(entitlementUpdated), wantReleased: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := &fakeQueueClient{}
+			s := New(aws.Config{}, 0, *zap.NewNop().Sugar(), nil,
+				WithAllowedTopicARNs(testTopicARN),
+				WithNotificationQueue(queue, "https://sqs.us-east-1.amazonaws.com/123456789012/notifications"))
+			s.SNSVerifier = signer.verifier()
+			s.EntitlementClient = failingEntitlementClient{}
+
+			s.handleQueueMessage(context.Background(), types.Message{
+				MessageId:     aws.String("message-1"),
+				ReceiptHandle: aws.String("receipt-1"),
+				Body:          aws.String(tt.body),
+			})
+
+			if queue.deleted != tt.wantDeleted || queue.released != tt.wantReleased {
+				t.Fatalf("deleted = %d, released = %d, want %d and %d", queue.deleted, queue.released, tt.wantDeleted, tt.wantReleased)
+			}
+		})
+	}
+}
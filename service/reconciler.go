@@ -0,0 +1,156 @@
+package service
+
+import (
+	"aws-markertplace-integration/db/models"
+	"aws-markertplace-integration/db/repo"
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultEntitlementReconcileInterval is the default interval between entitlement reconciliation runs
+	defaultEntitlementReconcileInterval = 6 * time.Hour
+	// entitlementReconcileLease is the lease that lets a single replica reconcile per interval
+	entitlementReconcileLease = "entitlement-reconciliation"
+	// maxReconcileEntitlementPages bounds the number of GetEntitlements pages fetched for a product
+	maxReconcileEntitlementPages = 10000
+	// maxReconcileErrorLength bounds the error stored for a product in a reconciliation report
+	maxReconcileErrorLength = 512
+)
+
+// WithEntitlementReconcileInterval sets the interval between entitlement reconciliation runs
+func WithEntitlementReconcileInterval(interval time.Duration) Option {
+	return func(s *Service) {
+		if interval > 0 {
+			s.entitlementReconcileInterval = interval
+		}
+	}
+}
+
+// reconcileEntitlements compares the entitlements of every known product with AWS and records
+// the differences. The lease is held for a whole interval rather than released after the run,
+// so that replicas ticking at different times still reconcile once per interval.
+func (s *Service) reconcileEntitlements(ctx context.Context) {
+	acquired, err := s.repo.AcquireLease(ctx, entitlementReconcileLease, s.instanceID, s.entitlementReconcileInterval)
+	if err != nil {
+		s.logger.Errorw("Failed to acquire entitlement reconciliation lease", "error", err.Error())
+		return
+	}
+	if !acquired {
+		s.logger.Infow("Entitlement reconciliation is handled by another replica")
+		return
+	}
+
+	productCodes, err := s.repo.ListProductCodes(ctx)
+	if err != nil {
+		s.logger.Errorw("Failed to list products for entitlement reconciliation", "error", err.Error())
+		return
+	}
+
+	report := models.EntitlementReconciliation{
+		Holder:    s.instanceID,
+		StartedAt: time.Now(),
+	}
+	for _, productCode := range productCodes {
+		if ctx.Err() != nil {
+			break
+		}
+		result := s.reconcileProduct(ctx, productCode)
+		report.Details = append(report.Details, result)
+		report.Products++
+		if result.Error != "" {
+			report.FailedProducts++
+		}
+		report.Created += result.Created
+		report.Changed += result.Changed
+		report.Removed += result.Removed
+		report.Unchanged += result.Unchanged
+	}
+	report.FinishedAt = time.Now()
+
+	// Use a fresh context so that the report of an interrupted run is stored too
+	saveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.repo.CreateEntitlementReconciliation(saveCtx, &report); err != nil {
+		s.logger.Errorw("Failed to store entitlement reconciliation report", "error", err.Error())
+	}
+
+	s.logger.Infow("Entitlement reconciliation finished",
+		"products", report.Products,
+		"failedProducts", report.FailedProducts,
+		"created", report.Created,
+		"changed", report.Changed,
+		"removed", report.Removed,
+		"unchanged", report.Unchanged,
+		"duration", report.FinishedAt.Sub(report.StartedAt).String())
+}
+
+// reconcileProduct records the differences between AWS and the persisted entitlements of a product
+func (s *Service) reconcileProduct(ctx context.Context, productCode string) models.ProductReconciliation {
+	result := models.ProductReconciliation{ProductCode: productCode}
+
+	entitlements, err := s.fetchAllEntitlements(ctx, GetEntitlementsRequest{ProductCode: productCode}, maxReconcileEntitlementPages)
+	if err != nil {
+		s.logger.Errorw("Failed to fetch entitlements for reconciliation",
+			"productCode", productCode,
+			"error", err.Error())
+		result.Error = truncateReconcileError(err.Error())
+		return result
+	}
+	result.Entitlements = len(entitlements.Entitlements)
+
+	changes, err := s.repo.UpdateEntitlements(ctx, repo.EntitlementScope{ProductCode: productCode}, *entitlements)
+	if err != nil {
+		s.logger.Errorw("Failed to record reconciled entitlements",
+			"productCode", productCode,
+			"error", err.Error())
+		result.Error = truncateReconcileError(err.Error())
+		return result
+	}
+
+	result.Created = len(changes.Created)
+	result.Changed = len(changes.Changed)
+	result.Removed = len(changes.Removed)
+	result.Unchanged = changes.Unchanged
+	if result.Created+result.Changed+result.Removed > 0 {
+		s.logger.Infow("Reconciled entitlement changes",
+			"productCode", productCode,
+			"created", changes.Created,
+			"changed", changes.Changed,
+			"removed", changes.Removed)
+	}
+	return result
+}
+
+// handleEntitlementReconciliations lists the most recent entitlement reconciliation reports
+func (s *Service) handleEntitlementReconciliations(c *gin.Context) {
+	limit := 20
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+		limit = n
+	}
+
+	reports, err := s.repo.ListEntitlementReconciliations(c.Request.Context(), limit)
+	if err != nil {
+		s.logger.Errorw("Failed to list entitlement reconciliations", "error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list reconciliations"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reconciliations": reports})
+}
+
+// truncateReconcileError shortens an error message to fit a reconciliation report
+func truncateReconcileError(message string) string {
+	if len(message) <= maxReconcileErrorLength {
+		return message
+	}
+	return message[:maxReconcileErrorLength]
+}
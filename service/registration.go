@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+// registrationTokenValidity is how long the outcome of a registration token is reused. AWS
+// Marketplace registration tokens are short-lived, so a repeat after this window is resolved again.
+const registrationTokenValidity = time.Hour
+
+// registrationResolveTimeout bounds the AWS calls of a shared registration resolution
+const registrationResolveTimeout = 10 * time.Second
+
+// registrationOutcome is the response to a registration token: either a redirect to the
+// onboarding form or a page to render. Outcomes with an expiry are reused for repeated
+// submissions of the same token; failures have none, so that a retry resolves the token again.
+type registrationOutcome struct {
+	redirect   string
+	template   string
+	statusCode int
+	data       gin.H
+	err        error
+	expiresAt  time.Time
+	// productCode selects the catalog details rendered on the page, once the token is resolved
+	productCode string
+}
+
+// registrationError is the outcome of a registration that failed
+func registrationError(err error, statusCode int, title, message string) registrationOutcome {
+	return registrationOutcome{
+		template:   "error.tmpl",
+		statusCode: statusCode,
+		data:       gin.H{"errorTitle": title, "errorMessage": message},
+		err:        err,
+	}
+}
+
+// registrationCache remembers the outcomes of registration tokens by their hash, so that a page
+// refresh or a retried POST does not call AWS again. Concurrent submissions of a token share a
+// single resolution. The cache is local to the replica.
+type registrationCache struct {
+	mu       sync.Mutex
+	outcomes map[string]registrationOutcome
+	inflight singleflight.Group
+}
+
+// get returns the cached outcome for a token hash if it has not expired
+func (rc *registrationCache) get(key string, now time.Time) (registrationOutcome, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	outcome, ok := rc.outcomes[key]
+	if !ok || !now.Before(outcome.expiresAt) {
+		return registrationOutcome{}, false
+	}
+	return outcome, true
+}
+
+// put caches an outcome and drops the ones that have expired
+func (rc *registrationCache) put(key string, outcome registrationOutcome, now time.Time) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.outcomes == nil {
+		rc.outcomes = make(map[string]registrationOutcome)
+	}
+	for k, cached := range rc.outcomes {
+		if !now.Before(cached.expiresAt) {
+			delete(rc.outcomes, k)
+		}
+	}
+	rc.outcomes[key] = outcome
+}
+
+// resolveRegistrationToken returns the outcome of a registration token, resolving it at most
+// once per validity window
+func (s *Service) resolveRegistrationToken(ctx context.Context, meteringClient MeteringClientInterface, token string) registrationOutcome {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	if outcome, ok := s.registrations.get(key, time.Now()); ok {
+		s.logger.Infow("Reusing outcome of a repeated registration token")
+		return outcome
+	}
+
+	result, _, shared := s.registrations.inflight.Do(key, func() (any, error) {
+		// A cached outcome may have been stored while waiting for the previous call
+		if outcome, ok := s.registrations.get(key, time.Now()); ok {
+			return outcome, nil
+		}
+		// The resolution is shared with concurrent requests, so it must not be cancelled
+		// when the request that started it goes away, but it must not hang them all either
+		resolveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), registrationResolveTimeout)
+		defer cancel()
+		outcome := s.resolveRegistration(resolveCtx, meteringClient, token)
+		if !outcome.expiresAt.IsZero() {
+			s.registrations.put(key, outcome, time.Now())
+		}
+		return outcome, nil
+	})
+	if shared {
+		s.logger.Infow("Collapsed concurrent submissions of a registration token")
+	}
+	return result.(registrationOutcome)
+}
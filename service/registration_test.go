@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/marketplaceentitlementservice"
+	entitlementtypes "github.com/aws/aws-sdk-go-v2/service/marketplaceentitlementservice/types"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacemetering"
+	"go.uber.org/zap"
+)
+
+// resolvingMeteringClient resolves every registration token to customer c1 of product p1 once
+// release is closed
+type resolvingMeteringClient struct {
+	fakeMeteringClient
+	release  chan struct{}
+	err      error
+	resolved atomic.Int32
+
+	mu sync.Mutex
+	// ctx is the context of the last ResolveCustomer call
+	ctx context.Context
+}
+
+func (f *resolvingMeteringClient) ResolveCustomer(ctx context.Context, params *marketplacemetering.ResolveCustomerInput, optFns ...func(*marketplacemetering.Options)) (*marketplacemetering.ResolveCustomerOutput, error) {
+	f.resolved.Add(1)
+	f.mu.Lock()
+	f.ctx = ctx
+	f.mu.Unlock()
+	<-f.release
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &marketplacemetering.ResolveCustomerOutput{
+		CustomerIdentifier:   aws.String("c1"),
+		CustomerAWSAccountId: aws.String("111111111111"),
+		ProductCode:          aws.String("p1"),
+	}, nil
+}
+
+// staticEntitlementClient returns a single entitlement for every customer
+type staticEntitlementClient struct{}
+
+func (staticEntitlementClient) GetEntitlements(ctx context.Context, params *marketplaceentitlementservice.GetEntitlementsInput, optFns ...func(*marketplaceentitlementservice.Options)) (*marketplaceentitlementservice.GetEntitlementsOutput, error) {
+	return &marketplaceentitlementservice.GetEntitlementsOutput{
+		Entitlements: []entitlementtypes.Entitlement{{
+			CustomerIdentifier: aws.String("c1"),
+			ProductCode:        params.ProductCode,
+			Dimension:          aws.String("seats"),
+			Value:              &entitlementtypes.EntitlementValue{IntegerValue: aws.Int32(10)},
+		}},
+	}, nil
+}
+
+func TestResolveRegistrationToken(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantResolved int32
+	}{
+		// Concurrent submissions share the resolution and later ones reuse its cached outcome
+		{name: "resolved once", wantResolved: 1},
+		// Failed resolutions are not cached, so every submission resolves the token again
+		{name: "failures are not cached", err: errors.New("throttled"), wantResolved: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &resolvingMeteringClient{release: make(chan struct{}), err: tt.err}
+			close(client.release)
+			s := New(aws.Config{}, 0, *zap.NewNop().Sugar(), nil, WithOnboardingSecret("test-secret"))
+			s.MeteringClient = client
+			s.EntitlementClient = staticEntitlementClient{}
+
+			outcomes := make([]registrationOutcome, 5)
+			if tt.err == nil {
+				var wg sync.WaitGroup
+				for i := range outcomes {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						outcomes[i] = s.resolveRegistrationToken(context.Background(), client, "token")
+					}()
+				}
+				wg.Wait()
+			} else {
+				for i := range outcomes {
+					outcomes[i] = s.resolveRegistrationToken(context.Background(), client, "token")
+				}
+			}
+
+			if resolved := client.resolved.Load(); resolved != tt.wantResolved {
+				t.Fatalf("ResolveCustomer called %d times, want %d", resolved, tt.wantResolved)
+			}
+			for i, outcome := range outcomes {
+				if (outcome.err != nil) != (tt.err != nil) {
+					t.Fatalf("outcome %d error = %v, want error %v", i, outcome.err, tt.err != nil)
+				}
+				if outcome.redirect != outcomes[0].redirect {
+					t.Fatalf("outcome %d redirects to %q, want the shared %q", i, outcome.redirect, outcomes[0].redirect)
+				}
+			}
+			if tt.err == nil && outcomes[0].redirect == "" {
+				t.Fatalf("outcome = %+v, want a redirect to onboarding", outcomes[0])
+			}
+		})
+	}
+}
+
+func TestResolveRegistrationTokenContext(t *testing.T) {
+	client := &resolvingMeteringClient{release: make(chan struct{})}
+	s := New(aws.Config{}, 0, *zap.NewNop().Sugar(), nil, WithOnboardingSecret("test-secret"))
+	s.MeteringClient = client
+	s.EntitlementClient = staticEntitlementClient{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan registrationOutcome)
+	go func() {
+		done <- s.resolveRegistrationToken(ctx, client, "token")
+	}()
+	for client.resolved.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The request that started the resolution goes away while AWS is called
+	cancel()
+	close(client.release)
+	outcome := <-done
+
+	if outcome.err != nil {
+		t.Fatalf("outcome error = %v, want the resolution to outlive the request", outcome.err)
+	}
+	client.mu.Lock()
+	deadline, ok := client.ctx.Deadline()
+	client.mu.Unlock()
+	if !ok || time.Until(deadline) > registrationResolveTimeout {
+		t.Fatalf("resolution deadline = %v, %v, want at most %v from now", deadline, ok, registrationResolveTimeout)
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/marketplaceentitlementservice"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacemetering"
+	"gopkg.in/yaml.v3"
+)
+
+// Seller holds the marketplace clients of one seller account and the products it lists
+type Seller struct {
+	Name              string
+	ProductCodes      []string
+	MeteringClient    MeteringClientInterface
+	EntitlementClient EntitlementClientInterface
+}
+
+// NewSeller creates the marketplace clients of a seller account from its AWS configuration
+func NewSeller(name string, conf aws.Config, productCodes ...string) Seller {
+	return Seller{
+		Name:              name,
+		ProductCodes:      productCodes,
+		MeteringClient:    marketplacemetering.NewFromConfig(conf),
+		EntitlementClient: marketplaceentitlementservice.NewFromConfig(conf),
+	}
+}
+
+// SellerConfig describes how to reach a seller account. Without a profile or a role, the
+// seller uses the default credentials of the runtime.
+type SellerConfig struct {
+	// Name identifies the seller in the /aws-marketplace/sellers/:seller/webhook route
+	Name    string `json:"name" yaml:"name"`
+	Region  string `json:"region" yaml:"region"`
+	Profile string `json:"profile" yaml:"profile"`
+	// AssumeRole is the role in the seller account, assumed with the profile or default credentials
+	AssumeRole   `yaml:",inline"`
+	ProductCodes []string `json:"product_codes" yaml:"product_codes"`
+}
+
+// sellersFile is the layout of a sellers file
+type sellersFile struct {
+	Sellers []SellerConfig `json:"sellers" yaml:"sellers"`
+}
+
+// LoadSellerConfigs reads the seller accounts from a YAML (.yaml, .yml) or JSON file
+func LoadSellerConfigs(path string) ([]SellerConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file sellersFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &file)
+	default:
+		err = json.Unmarshal(content, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sellers: %w", err)
+	}
+
+	names := make(map[string]bool, len(file.Sellers))
+	owners := make(map[string]string)
+	for _, seller := range file.Sellers {
+		if err := seller.validate(); err != nil {
+			return nil, err
+		}
+		if names[seller.Name] {
+			return nil, fmt.Errorf("seller %s is listed more than once", seller.Name)
+		}
+		names[seller.Name] = true
+		for _, productCode := range seller.ProductCodes {
+			if owner, ok := owners[productCode]; ok {
+				return nil, fmt.Errorf("product %s is listed for both seller %s and seller %s", productCode, owner, seller.Name)
+			}
+			owners[productCode] = seller.Name
+		}
+	}
+	return file.Sellers, nil
+}
+
+// validate checks that a seller has a name that can be used in a URL path and lists its products
+func (c SellerConfig) validate() error {
+	if c.Name == "" {
+		return errors.New("seller name is required")
+	}
+	if url.PathEscape(c.Name) != c.Name {
+		return fmt.Errorf("seller %q: name must only contain characters allowed in a URL path segment", c.Name)
+	}
+	if len(c.ProductCodes) == 0 {
+		return fmt.Errorf("seller %s: product_codes is required", c.Name)
+	}
+	for _, productCode := range c.ProductCodes {
+		if productCode == "" {
+			return fmt.Errorf("seller %s: product codes must not be empty", c.Name)
+		}
+	}
+	if c.RoleARN == "" {
+		if c.ExternalID != "" || c.SessionName != "" {
+			return fmt.Errorf("seller %s: external_id and session_name require role_arn", c.Name)
+		}
+		return nil
+	}
+	if err := c.AssumeRole.validate(); err != nil {
+		return fmt.Errorf("seller %s: %w", c.Name, err)
+	}
+	return nil
+}
+
+// WithSellers serves the products of several seller accounts. Calls for a product listed by a
+// seller use the clients of that seller; other products use the default clients.
+func WithSellers(sellers ...Seller) Option {
+	return func(s *Service) {
+		if s.sellers == nil {
+			s.sellers = make(map[string]*Seller, len(sellers))
+			s.productSellers = make(map[string]*Seller)
+		}
+		for _, seller := range sellers {
+			s.sellers[seller.Name] = &seller
+			for _, productCode := range seller.ProductCodes {
+				s.productSellers[productCode] = &seller
+			}
+		}
+	}
+}
+
+// meteringClient returns the metering client of the seller that lists a product
+func (s *Service) meteringClient(productCode string) MeteringClientInterface {
+	if seller, ok := s.productSellers[productCode]; ok {
+		return seller.MeteringClient
+	}
+	return s.MeteringClient
+}
+
+// entitlementClient returns the entitlement client of the seller that lists a product
+func (s *Service) entitlementClient(productCode string) EntitlementClientInterface {
+	if seller, ok := s.productSellers[productCode]; ok {
+		return seller.EntitlementClient
+	}
+	return s.EntitlementClient
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.uber.org/zap"
+)
+
+func TestResolveRegistrationSeller(t *testing.T) {
+	defaultClient := &resolvingMeteringClient{release: make(chan struct{})}
+	sellerA := &resolvingMeteringClient{release: make(chan struct{})}
+	sellerB := &resolvingMeteringClient{release: make(chan struct{})}
+	for _, client := range []*resolvingMeteringClient{defaultClient, sellerA, sellerB} {
+		close(client.release)
+	}
+
+	tests := []struct {
+		name       string
+		sellers    []Seller
+		resolvedBy MeteringClientInterface
+		wantStatus int
+	}{
+		{name: "default seller of an unlisted product", resolvedBy: defaultClient},
+		{
+			name:       "seller that lists the product",
+			sellers:    []Seller{{Name: "a", ProductCodes: []string{"p1"}, MeteringClient: sellerA, EntitlementClient: staticEntitlementClient{}}},
+			resolvedBy: sellerA,
+		},
+		{
+			name: "seller that does not list the product",
+			sellers: []Seller{
+				{Name: "a", ProductCodes: []string{"p1"}, MeteringClient: sellerA, EntitlementClient: staticEntitlementClient{}},
+				{Name: "b", ProductCodes: []string{"p2"}, MeteringClient: sellerB, EntitlementClient: staticEntitlementClient{}},
+			},
+			resolvedBy: sellerB,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "default seller of a product listed by another seller",
+			sellers:    []Seller{{Name: "a", ProductCodes: []string{"p1"}, MeteringClient: sellerA, EntitlementClient: staticEntitlementClient{}}},
+			resolvedBy: defaultClient,
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(aws.Config{}, 0, *zap.NewNop().Sugar(), nil, WithOnboardingSecret("test-secret"), WithSellers(tt.sellers...))
+			s.MeteringClient = defaultClient
+			s.EntitlementClient = staticEntitlementClient{}
+
+			outcome := s.resolveRegistration(context.Background(), tt.resolvedBy, "token")
+
+			if tt.wantStatus == 0 {
+				if outcome.err != nil || outcome.redirect == "" {
+					t.Fatalf("outcome = %+v, want a redirect to onboarding", outcome)
+				}
+				return
+			}
+			if outcome.err == nil || outcome.statusCode != tt.wantStatus || outcome.redirect != "" {
+				t.Fatalf("outcome = %+v, want a %d error page", outcome, tt.wantStatus)
+			}
+			if !outcome.expiresAt.IsZero() {
+				t.Fatalf("outcome expires at %v, want a rejection that is not cached", outcome.expiresAt)
+			}
+		})
+	}
+}
@@ -1,10 +1,16 @@
 package service
 
 import (
+	"aws-markertplace-integration/db/repo"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/marketplaceentitlementservice"
@@ -14,20 +20,62 @@ import (
 )
 
 type Service struct {
-	port              int
-	logger            *zap.SugaredLogger
-	MeteringClient    MeteringClientInterface
-	EntitlementClient EntitlementClientInterface
-	handler           http.Handler
+	port                         int
+	logger                       *zap.SugaredLogger
+	MeteringClient               MeteringClientInterface
+	EntitlementClient            EntitlementClientInterface
+	SNSVerifier                  *SNSVerifier
+	allowedTopicARNs             []string
+	repo                         repo.Repository
+	httpClient                   *http.Client
+	queue                        *notificationQueue
+	internalAPIKey               string
+	adminAPIKey                  string
+	usageSubmitInterval          time.Duration
+	lateUsagePolicy              repo.LateUsagePolicy
+	entitlementMaxAge            time.Duration
+	entitlementReconcileInterval time.Duration
+	instanceID                   string
+	notifier                     Notifier
+	expiryCheckInterval          time.Duration
+	expiryWarningDays            []int
+	webhooks                     []WebhookEndpoint
+	onboardingSecret             []byte
+	onboardingTokenTTL           time.Duration
+	registrations                registrationCache
+	catalog                      []ProductCatalogEntry
+	sellers                      map[string]*Seller
+	productSellers               map[string]*Seller
+	handler                      http.Handler
 }
 
-func New(conf aws.Config, port int, logger zap.SugaredLogger) *Service {
-	return &Service{
-		port:              port,
-		logger:            logger.Named("service"),
-		MeteringClient:    marketplacemetering.NewFromConfig(conf),
-		EntitlementClient: marketplaceentitlementservice.NewFromConfig(conf),
+// Option configures optional features of the Service
+type Option func(*Service)
+
+func New(conf aws.Config, port int, logger zap.SugaredLogger, repository repo.Repository, opts ...Option) *Service {
+	s := &Service{
+		port:                         port,
+		logger:                       logger.Named("service"),
+		MeteringClient:               marketplacemetering.NewFromConfig(conf),
+		EntitlementClient:            marketplaceentitlementservice.NewFromConfig(conf),
+		SNSVerifier:                  NewSNSVerifier(NewHTTPCertificateFetcher(nil, nil)),
+		repo:                         repository,
+		httpClient:                   &http.Client{Timeout: 10 * time.Second},
+		usageSubmitInterval:          defaultUsageSubmitInterval,
+		lateUsagePolicy:              repo.LateUsageCarryForward,
+		entitlementMaxAge:            defaultEntitlementMaxAge,
+		entitlementReconcileInterval: defaultEntitlementReconcileInterval,
+		instanceID:                   newInstanceID(),
+		expiryCheckInterval:          defaultExpiryCheckInterval,
+		expiryWarningDays:            defaultExpiryWarningDays,
+		onboardingTokenTTL:           defaultOnboardingTokenTTL,
+	}
+	s.notifier = logNotifier{logger: s.logger}
+	for _, opt := range opts {
+		opt(s)
 	}
+	s.ensureOnboardingSecret()
+	return s
 }
 
 func (s *Service) SetupRouter() {
@@ -41,9 +89,38 @@ func (s *Service) SetupRouter() {
 		c.HTML(http.StatusOK, "index.html", nil)
 	})
 	router.POST("/aws-marketplace/webhook", s.handleMarketplaceToken)
-	router.POST("/aws-marketplace/onboarding/:customerIdentifier", s.handleCustomerDetails)
-	router.GET("/aws-marketplace/onboarding/:customerIdentifier", s.handlerForm)
+	router.POST("/aws-marketplace/sellers/:seller/webhook", s.handleMarketplaceToken)
+	router.POST("/aws-marketplace/notifications", s.handleSubscriptionNotification)
+	router.POST("/aws-marketplace/onboarding/:token", s.handleCustomerDetails)
+	router.GET("/aws-marketplace/onboarding/:token", s.handlerForm)
 	router.GET("/health", handleHealthCheck)
+
+	if len(s.allowedTopicARNs) == 0 {
+		s.logger.Warn("No SNS topic ARNs configured, subscription notifications are rejected")
+	}
+
+	if s.internalAPIKey == "" {
+		s.logger.Warn("No internal API key configured, internal API is disabled")
+	}
+	api := router.Group("/api/v1", s.requireAPIKey(s.internalAPIKey), s.requireRepository)
+	api.POST("/usage", s.handleUsageIngestion)
+	api.GET("/usage/dead-letters", s.handleUsageDeadLetters)
+	api.GET("/subscriptions/:customerIdentifier/:productCode", s.handleSubscriptionStatus)
+	api.GET("/customers/:customerIdentifier/entitlements", s.handleCurrentEntitlements)
+	api.GET("/customers/:customerIdentifier/entitlements/history", s.handleEntitlementHistory)
+	api.GET("/entitlements/check", s.handleEntitlementCheck)
+	api.GET("/entitlements/reconciliations", s.handleEntitlementReconciliations)
+	api.GET("/webhooks/deliveries", s.handleWebhookDeliveries)
+
+	if s.adminAPIKey == "" {
+		s.logger.Warn("No admin API key configured, admin API is disabled")
+	}
+	admin := router.Group("/admin/v1", s.requireAPIKey(s.adminAPIKey), s.requireRepository)
+	admin.GET("/customers", s.handleAdminListCustomers)
+	admin.GET("/customers/:customerIdentifier", s.handleAdminGetCustomer)
+	admin.GET("/products", s.handleAdminListProducts)
+	admin.PUT("/products/:productCode", s.handleAdminPutProduct)
+	admin.PATCH("/products/:productCode", s.handleAdminUpdateProduct)
 	s.handler = router
 }
 
@@ -64,10 +141,73 @@ func (s *Service) Run(ctx context.Context) {
 			s.logger.Errorf("ListenAndServe error: %v", err)
 		}
 	}()
+
+	var workers sync.WaitGroup
+	startWorker := func(worker func(context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			worker(ctx)
+		}()
+	}
+	if s.queue != nil {
+		startWorker(s.consumeNotificationQueue)
+	}
+	if s.repo != nil {
+		if len(s.catalog) > 0 {
+			s.syncProductCatalog(ctx)
+		}
+		startWorker(func(ctx context.Context) {
+			s.runPeriodically(ctx, "usage-submission", s.usageSubmitInterval, s.submitClosedUsageHours)
+		})
+		startWorker(func(ctx context.Context) {
+			s.runPeriodically(ctx, "entitlement-reconciliation", s.entitlementReconcileInterval, s.reconcileEntitlements)
+		})
+		startWorker(func(ctx context.Context) {
+			s.runPeriodically(ctx, "entitlement-expiry", s.expiryCheckInterval, s.checkEntitlementExpiry)
+		})
+		startWorker(func(ctx context.Context) {
+			s.runPeriodically(ctx, "outbox-dispatch", outboxDispatchInterval, s.dispatchOutbox)
+		})
+		if len(s.webhooks) > 0 {
+			startWorker(func(ctx context.Context) {
+				s.runPeriodically(ctx, "webhook-dispatch", webhookDispatchInterval, s.dispatchWebhooks)
+			})
+		}
+	}
+
 	<-ctx.Done()
 	s.logger.Info("Shutdown signal received")
 	if err := srv.Shutdown(context.Background()); err != nil {
 		s.logger.Errorf("Error during shutdown: %v", err)
 	}
+	workers.Wait()
 	s.logger.Info("Server stopped gracefully")
 }
+
+// newInstanceID identifies this replica as the holder of job leases
+func newInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// runPeriodically calls job every interval until ctx is cancelled
+func (s *Service) runPeriodically(ctx context.Context, name string, interval time.Duration, job func(context.Context)) {
+	s.logger.Infow("Started background job", "job", name, "interval", interval.String())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Infow("Background job stopped", "job", name)
+			return
+		case <-ticker.C:
+			job(ctx)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SNS signature verification errors
+var (
+	ErrUnsupportedSignatureVersion = errors.New("unsupported signature version")
+	ErrInvalidSigningCertURL       = errors.New("invalid signing certificate URL")
+	ErrInvalidSignature            = errors.New("invalid message signature")
+	ErrCertificateExpired          = errors.New("signing certificate is not valid at this time")
+)
+
+// defaultSNSCertHost matches the hosts SNS serves its signing certificates from
+var defaultSNSCertHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// maxCertificateSize bounds the size of a downloaded signing certificate
+const maxCertificateSize = 64 * 1024
+
+// CertificateFetcher retrieves the X.509 certificate referenced by an SNS message's SigningCertURL.
+type CertificateFetcher interface {
+	FetchCertificate(ctx context.Context, certURL string) (*x509.Certificate, error)
+}
+
+// HTTPCertificateFetcher downloads SNS signing certificates over HTTPS and caches them by URL.
+type HTTPCertificateFetcher struct {
+	client      *http.Client
+	allowedHost *regexp.Regexp
+
+	mu    sync.RWMutex
+	cache map[string]*x509.Certificate
+}
+
+// NewHTTPCertificateFetcher creates a fetcher that only accepts certificate URLs served over HTTPS
+// from hosts matching allowedHost. A nil client or pattern falls back to the SNS defaults.
+func NewHTTPCertificateFetcher(client *http.Client, allowedHost *regexp.Regexp) *HTTPCertificateFetcher {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if allowedHost == nil {
+		allowedHost = defaultSNSCertHost
+	}
+	return &HTTPCertificateFetcher{
+		client:      client,
+		allowedHost: allowedHost,
+		cache:       make(map[string]*x509.Certificate),
+	}
+}
+
+// FetchCertificate returns the certificate at certURL, downloading it on first use
+func (f *HTTPCertificateFetcher) FetchCertificate(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	parsed, err := url.Parse(certURL)
+	if err != nil || parsed.Scheme != "https" || !f.allowedHost.MatchString(parsed.Host) || !strings.HasSuffix(parsed.Path, ".pem") {
+		return nil, ErrInvalidSigningCertURL
+	}
+
+	f.mu.RLock()
+	cert, ok := f.cache[certURL]
+	f.mu.RUnlock()
+	if ok {
+		return cert, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, certURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build certificate request: %w", err)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download signing certificate: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download signing certificate: unexpected status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCertificateSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(body)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("signing certificate is not a PEM encoded certificate")
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing certificate: %w", err)
+	}
+
+	f.mu.Lock()
+	f.cache[certURL] = cert
+	f.mu.Unlock()
+	return cert, nil
+}
+
+// SNSVerifier verifies the SignatureVersion 1 and 2 signatures of SNS messages.
+type SNSVerifier struct {
+	fetcher CertificateFetcher
+	now     func() time.Time
+}
+
+// NewSNSVerifier creates a verifier that resolves signing certificates through fetcher
+func NewSNSVerifier(fetcher CertificateFetcher) *SNSVerifier {
+	return &SNSVerifier{
+		fetcher: fetcher,
+		now:     time.Now,
+	}
+}
+
+// Verify checks that msg was signed by the certificate referenced in its SigningCertURL
+func (v *SNSVerifier) Verify(ctx context.Context, msg SNSMessage) error {
+	var hash crypto.Hash
+	switch msg.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedSignatureVersion, msg.SignatureVersion)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	cert, err := v.fetcher.FetchCertificate(ctx, msg.SigningCertURL)
+	if err != nil {
+		return err
+	}
+
+	now := v.now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return ErrCertificateExpired
+	}
+
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: signing certificate does not hold an RSA key", ErrInvalidSignature)
+	}
+
+	stringToSign, err := snsStringToSign(msg)
+	if err != nil {
+		return err
+	}
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(stringToSign))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(stringToSign))
+		digest = sum[:]
+	}
+
+	if err := rsa.VerifyPKCS1v15(publicKey, hash, digest, signature); err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// snsStringToSign builds the canonical string SNS signs for the given message type
+func snsStringToSign(msg SNSMessage) (string, error) {
+	var fields [][2]string
+	switch msg.Type {
+	case snsTypeNotification:
+		fields = append(fields, [2]string{"Message", msg.Message}, [2]string{"MessageId", msg.MessageID})
+		if msg.Subject != "" {
+			fields = append(fields, [2]string{"Subject", msg.Subject})
+		}
+		fields = append(fields,
+			[2]string{"Timestamp", msg.Timestamp},
+			[2]string{"TopicArn", msg.TopicArn},
+			[2]string{"Type", msg.Type})
+	case snsTypeSubscriptionConfirmation, snsTypeUnsubscribeConfirmation:
+		fields = append(fields,
+			[2]string{"Message", msg.Message},
+			[2]string{"MessageId", msg.MessageID},
+			[2]string{"SubscribeURL", msg.SubscribeURL},
+			[2]string{"Timestamp", msg.Timestamp},
+			[2]string{"Token", msg.Token},
+			[2]string{"TopicArn", msg.TopicArn},
+			[2]string{"Type", msg.Type})
+	default:
+		return "", fmt.Errorf("%w: unsupported message type %q", ErrInvalidSignature, msg.Type)
+	}
+
+	var b strings.Builder
+	for _, field := range fields {
+		b.WriteString(field[0])
+		b.WriteByte('\n')
+		b.WriteString(field[1])
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
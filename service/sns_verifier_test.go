@@ -0,0 +1,282 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const testTopicARN = "arn:aws:sns:us-east-1:123456789012:aws-mp-subscription-notification-test"
+
+// snsTestSigner signs SNS messages with a certificate issued by a test CA and serves that
+// certificate from a stand-in HTTPS server
+type snsTestSigner struct {
+	key     *rsa.PrivateKey
+	server  *httptest.Server
+	certURL string
+}
+
+func newSNSTestSigner(t *testing.T) *snsTestSigner {
+	t.Helper()
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test SNS CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "sns.us-east-1.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/SimpleNotificationService-test.pem" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(leafPEM)
+	}))
+	t.Cleanup(server.Close)
+
+	return &snsTestSigner{
+		key:     key,
+		server:  server,
+		certURL: server.URL + "/SimpleNotificationService-test.pem",
+	}
+}
+
+// verifier returns a verifier that downloads certificates from the stand-in server only
+func (ts *snsTestSigner) verifier() *SNSVerifier {
+	host := strings.TrimPrefix(ts.server.URL, "https://")
+	return NewSNSVerifier(NewHTTPCertificateFetcher(ts.server.Client(), regexp.MustCompile(`^`+regexp.QuoteMeta(host)+`$`)))
+}
+
+// sign sets the signature fields of msg for the given signature version
+func (ts *snsTestSigner) sign(t *testing.T, msg SNSMessage, version string) SNSMessage {
+	t.Helper()
+	msg.SignatureVersion = version
+	if msg.SigningCertURL == "" {
+		msg.SigningCertURL = ts.certURL
+	}
+	stringToSign, err := snsStringToSign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signature []byte
+	if version == "1" {
+		sum := sha1.Sum([]byte(stringToSign))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, ts.key, crypto.SHA1, sum[:])
+	} else {
+		sum := sha256.Sum256([]byte(stringToSign))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, ts.key, crypto.SHA256, sum[:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Signature = base64.StdEncoding.EncodeToString(signature)
+	return msg
+}
+
+func testNotification() SNSMessage {
+	return SNSMessage{
+		Type:      snsTypeNotification,
+		MessageID: "3b1f0c4e-7d1a-4c4e-9a43-0f2f6d0b8a11",
+		TopicArn:  testTopicARN,
+		Message:   `{"action":"unsubscribe-success","customer-identifier":"c1","product-code":"p1"}`,
+		Timestamp: "2024-11-01T12:00:00.000Z",
+	}
+}
+
+func TestSNSVerifierVerify(t *testing.T) {
+	signer := newSNSTestSigner(t)
+	verifier := signer.verifier()
+
+	confirmation := testNotification()
+	confirmation.Type = snsTypeSubscriptionConfirmation
+	confirmation.Token = "token"
+	confirmation.SubscribeURL = "https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription"
+
+	tests := []struct {
+		name    string
+		msg     SNSMessage
+		version string
+		tamper  func(*SNSMessage)
+		wantErr error
+	}{
+		{name: "signature version 1", msg: testNotification(), version: "1"},
+		{name: "signature version 2", msg: testNotification(), version: "2"},
+		{name: "subscription confirmation", msg: confirmation, version: "2"},
+		{
+			name:    "tampered message",
+			msg:     testNotification(),
+			version: "2",
+			tamper:  func(m *SNSMessage) { m.Message = strings.Replace(m.Message, "c1", "c2", 1) },
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "tampered topic",
+			msg:     testNotification(),
+			version: "1",
+			tamper:  func(m *SNSMessage) { m.TopicArn = "arn:aws:sns:us-east-1:999999999999:other" },
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "tampered subscribe URL",
+			msg:     confirmation,
+			version: "2",
+			tamper:  func(m *SNSMessage) { m.SubscribeURL = "https://attacker.amazonaws.com/" },
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "certificate on another host",
+			msg:     testNotification(),
+			version: "2",
+			tamper:  func(m *SNSMessage) { m.SigningCertURL = "https://sns.attacker.example.com/cert.pem" },
+			wantErr: ErrInvalidSigningCertURL,
+		},
+		{
+			name:    "certificate over plain HTTP",
+			msg:     testNotification(),
+			version: "2",
+			tamper:  func(m *SNSMessage) { m.SigningCertURL = strings.Replace(signer.certURL, "https://", "http://", 1) },
+			wantErr: ErrInvalidSigningCertURL,
+		},
+		{
+			name:    "unsupported signature version",
+			msg:     testNotification(),
+			version: "2",
+			tamper:  func(m *SNSMessage) { m.SignatureVersion = "3" },
+			wantErr: ErrUnsupportedSignatureVersion,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := signer.sign(t, tt.msg, tt.version)
+			if tt.tamper != nil {
+				tt.tamper(&msg)
+			}
+
+			err := verifier.Verify(context.Background(), msg)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("Verify() error = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSNSVerifierExpiredCertificate(t *testing.T) {
+	signer := newSNSTestSigner(t)
+	verifier := signer.verifier()
+	verifier.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+	msg := signer.sign(t, testNotification(), "2")
+	if err := verifier.Verify(context.Background(), msg); !errors.Is(err, ErrCertificateExpired) {
+		t.Fatalf("Verify() error = %v, want %v", err, ErrCertificateExpired)
+	}
+}
+
+// failingCertificateFetcher fails every download, as when the certificate host is unreachable
+type failingCertificateFetcher struct{}
+
+func (failingCertificateFetcher) FetchCertificate(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	return nil, errors.New("connection reset by peer")
+}
+
+func TestHandleSubscriptionNotificationAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	signer := newSNSTestSigner(t)
+
+	tests := []struct {
+		name       string
+		topicARN   string
+		fetcher    CertificateFetcher
+		tamper     bool
+		wantStatus int
+	}{
+		{name: "allowed topic", topicARN: testTopicARN, wantStatus: http.StatusOK},
+		{name: "validly signed message from another topic", topicARN: "arn:aws:sns:us-east-1:999999999999:forged", wantStatus: http.StatusForbidden},
+		{name: "invalid signature", topicARN: testTopicARN, tamper: true, wantStatus: http.StatusForbidden},
+		// A client error would make SNS drop the notification for good
+		{name: "certificate download fails", topicARN: testTopicARN, fetcher: failingCertificateFetcher{}, wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(aws.Config{}, 0, *zap.NewNop().Sugar(), nil, WithAllowedTopicARNs(testTopicARN))
+			s.SNSVerifier = signer.verifier()
+			if tt.fetcher != nil {
+				s.SNSVerifier = NewSNSVerifier(tt.fetcher)
+			}
+
+			msg := testNotification()
+			msg.TopicArn = tt.topicARN
+			msg = signer.sign(t, msg, "2")
+			if tt.tamper {
+				msg.Message = `{"action":"subscribe-success","customer-identifier":"c1","product-code":"p1"}`
+			}
+			body, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/aws-marketplace/notifications", strings.NewReader(string(body)))
+			s.handleSubscriptionNotification(c)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"aws-markertplace-integration/db/models"
+	"aws-markertplace-integration/db/repo"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Reasons recorded for subscription transitions made by the resolve flow. Transitions driven
+// by notifications record the marketplace action instead.
+const (
+	transitionReasonResolveCustomer     = "resolve-customer"
+	transitionReasonEntitlementsPresent = "entitlements-present"
+)
+
+// advanceSubscription moves a subscription forward from the resolve flow. The flow can run
+// after a notification already moved the subscription further, so transitions rejected by
+// the state machine are expected and only logged.
+func (s *Service) advanceSubscription(ctx context.Context, customerIdentifier, productCode string, status models.SubscriptionStatus, reason string) error {
+	_, err := s.repo.TransitionSubscription(ctx, customerIdentifier, productCode, status, reason)
+	if errors.Is(err, repo.ErrInvalidSubscriptionTransition) {
+		s.logger.Infow("Subscription not advanced",
+			"customerIdentifier", customerIdentifier,
+			"productCode", productCode,
+			"status", status,
+			"reason", err.Error())
+		return nil
+	}
+	return err
+}
+
+// handleSubscriptionStatus reports the subscription state of a customer for a product and
+// whether the customer is allowed to use it
+func (s *Service) handleSubscriptionStatus(c *gin.Context) {
+	customerIdentifier := c.Param("customerIdentifier")
+	productCode := c.Param("productCode")
+
+	subscription, err := s.repo.GetSubscription(c.Request.Context(), customerIdentifier, productCode)
+	if errors.Is(err, repo.ErrSubscriptionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	}
+	if err != nil {
+		s.logger.Errorw("Failed to get subscription",
+			"customerIdentifier", customerIdentifier,
+			"productCode", productCode,
+			"error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get subscription"})
+		return
+	}
+
+	transitions, err := s.repo.ListSubscriptionTransitions(c.Request.Context(), customerIdentifier, productCode)
+	if err != nil {
+		s.logger.Errorw("Failed to list subscription transitions",
+			"customerIdentifier", customerIdentifier,
+			"productCode", productCode,
+			"error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get subscription"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SubscriptionResponse{
+		CustomerIdentifier: subscription.CustomerIdentifier,
+		ProductCode:        subscription.ProductCode,
+		Status:             subscription.Status,
+		Allowed:            subscription.Status.AllowsAccess(),
+		CreatedAt:          subscription.CreatedAt,
+		UpdatedAt:          subscription.UpdatedAt,
+		Transitions:        transitions,
+	})
+}
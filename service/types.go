@@ -1,11 +1,14 @@
 package service
 
 import (
+	"aws-markertplace-integration/db/models"
 	"aws-markertplace-integration/db/repo"
 	"context"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/marketplaceentitlementservice"
 	"github.com/aws/aws-sdk-go-v2/service/marketplacemetering"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
 )
 
 // EntitlementClientInterface defines the methods for interacting with the AWS Marketplace Entitlement Service.
@@ -24,6 +27,13 @@ type MeteringClientInterface interface {
 	ResolveCustomer(ctx context.Context, params *marketplacemetering.ResolveCustomerInput, optFns ...func(*marketplacemetering.Options)) (*marketplacemetering.ResolveCustomerOutput, error)
 }
 
+// QueueClientInterface defines the methods for consuming notifications from Amazon SQS.
+type QueueClientInterface interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
 // GetEntitlementsRequest represents a request to get entitlements for a customer.
 type GetEntitlementsRequest struct {
 	CustomerIdentifier string  `json:"customerIdentifier"`
@@ -65,11 +75,160 @@ type CustomerDetailsResponse struct {
 
 // CustomerDetailsRequest represents the expected request payload
 type CustomerDetailsRequest struct {
-	CustomerIdentifier string `form:"customer_identifier" binding:"required"`
-	Name               string `form:"name" binding:"required"`
-	Email              string `form:"email" binding:"required,email"`
-	Phone              string `form:"phone" binding:"required"`
-	JobRole            string `form:"job_role" binding:"required"`
-	Company            string `form:"company" binding:"required"`
-	Country            string `form:"country" binding:"required"`
+	Name    string `form:"name" binding:"required"`
+	Email   string `form:"email" binding:"required,email"`
+	Phone   string `form:"phone" binding:"required"`
+	JobRole string `form:"job_role" binding:"required"`
+	Company string `form:"company" binding:"required"`
+	Country string `form:"country" binding:"required"`
+}
+
+// SNSMessage represents the JSON envelope of a message delivered by Amazon SNS.
+type SNSMessage struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token,omitempty"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject,omitempty"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL,omitempty"`
+	UnsubscribeURL   string `json:"UnsubscribeURL,omitempty"`
+}
+
+// MarketplaceNotification represents an AWS Marketplace SaaS notification carried in an SNS message.
+type MarketplaceNotification struct {
+	Action             string `json:"action"`
+	CustomerIdentifier string `json:"customer-identifier"`
+	ProductCode        string `json:"product-code"`
+	OfferIdentifier    string `json:"offer-identifier,omitempty"`
+}
+
+// UsageRecordRequest represents a single usage record reported by a product backend.
+type UsageRecordRequest struct {
+	CustomerIdentifier string    `json:"customer_identifier" binding:"required"`
+	ProductCode        string    `json:"product_code" binding:"required"`
+	Dimension          string    `json:"dimension" binding:"required"`
+	Quantity           *int64    `json:"quantity" binding:"required,min=0,max=2147483647"`
+	Timestamp          time.Time `json:"timestamp" binding:"required"`
+	IdempotencyKey     string    `json:"idempotency_key,omitempty" binding:"max=255"`
+	// Allocations optionally split the quantity across sets of tags. Their quantities must add up to Quantity.
+	Allocations []UsageAllocationRequest `json:"allocations,omitempty" binding:"omitempty,max=100,dive"`
+}
+
+// UsageAllocationRequest represents the part of a usage record's quantity attributed to a set of tags.
+type UsageAllocationRequest struct {
+	Quantity *int64            `json:"quantity" binding:"required,min=0,max=2147483647"`
+	Tags     []UsageTagRequest `json:"tags,omitempty" binding:"omitempty,max=5,dive"`
+}
+
+// UsageTagRequest represents a cost allocation tag.
+type UsageTagRequest struct {
+	Key   string `json:"key" binding:"required,max=100"`
+	Value string `json:"value" binding:"required,max=256"`
+}
+
+// UsageIngestionRequest represents the expected usage ingestion payload.
+type UsageIngestionRequest struct {
+	Records []UsageRecordRequest `json:"records" binding:"required,min=1,max=500,dive"`
+}
+
+// UsageRecordAck represents the ingestion outcome of a single usage record.
+type UsageRecordAck struct {
+	UsageRecordID  int64  `json:"usage_record_id,omitempty"`
+	UsageBucketID  int64  `json:"usage_bucket_id,omitempty"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	Status         string `json:"status"`
+}
+
+// UsageIngestionResponse represents the usage ingestion response.
+type UsageIngestionResponse struct {
+	Records []UsageRecordAck `json:"records"`
+}
+
+// SubscriptionResponse represents the state of a customer's subscription to a product.
+type SubscriptionResponse struct {
+	CustomerIdentifier string                          `json:"customer_identifier"`
+	ProductCode        string                          `json:"product_code"`
+	Status             models.SubscriptionStatus       `json:"status"`
+	Allowed            bool                            `json:"allowed"`
+	CreatedAt          time.Time                       `json:"created_at"`
+	UpdatedAt          time.Time                       `json:"updated_at"`
+	Transitions        []models.SubscriptionTransition `json:"transitions"`
+}
+
+// EntitlementValueResponse represents a persisted entitlement value. Only the field matching Type is set.
+type EntitlementValueResponse struct {
+	Type         models.ValueType `json:"type"`
+	BooleanValue *bool            `json:"boolean_value,omitempty"`
+	DoubleValue  *float64         `json:"double_value,omitempty"`
+	IntegerValue *int64           `json:"integer_value,omitempty"`
+	StringValue  *string          `json:"string_value,omitempty"`
+}
+
+// CurrentEntitlement represents the latest version of an entitlement dimension.
+type CurrentEntitlement struct {
+	ProductCode    string                    `json:"product_code"`
+	Dimension      string                    `json:"dimension"`
+	Value          *EntitlementValueResponse `json:"value"`
+	ExpirationDate *time.Time                `json:"expiration_date,omitempty"`
+	UpdatedAt      time.Time                 `json:"updated_at"`
+}
+
+// CurrentEntitlementsResponse represents the current entitlements of a customer.
+type CurrentEntitlementsResponse struct {
+	CustomerIdentifier string               `json:"customer_identifier"`
+	Entitlements       []CurrentEntitlement `json:"entitlements"`
+}
+
+// EntitlementChange represents a change of an entitlement dimension. OldValue is nil for the
+// first version of a dimension, and NewValue is nil when the dimension was removed.
+type EntitlementChange struct {
+	ProductCode    string                    `json:"product_code"`
+	Dimension      string                    `json:"dimension"`
+	OldValue       *EntitlementValueResponse `json:"old_value"`
+	NewValue       *EntitlementValueResponse `json:"new_value"`
+	Removed        bool                      `json:"removed"`
+	ExpirationDate *time.Time                `json:"expiration_date,omitempty"`
+	ChangedAt      time.Time                 `json:"changed_at"`
+}
+
+// EntitlementHistoryResponse represents the entitlement change timeline of a customer.
+type EntitlementHistoryResponse struct {
+	CustomerIdentifier string              `json:"customer_identifier"`
+	Changes            []EntitlementChange `json:"changes"`
+}
+
+// EntitlementCheckResponse represents the answer to an entitlement check. Entitled is true when
+// the customer has the dimension and it has not expired; the meaning of the value is up to the caller.
+type EntitlementCheckResponse struct {
+	CustomerIdentifier string                    `json:"customer_identifier"`
+	ProductCode        string                    `json:"product_code"`
+	Dimension          string                    `json:"dimension"`
+	Entitled           bool                      `json:"entitled"`
+	Value              *EntitlementValueResponse `json:"value"`
+	ExpirationDate     *time.Time                `json:"expiration_date,omitempty"`
+	Expired            bool                      `json:"expired"`
+	Source             string                    `json:"source"`
+	Stale              bool                      `json:"stale"`
+	CheckedAt          time.Time                 `json:"checked_at"`
+}
+
+// CustomerDetailResponse represents a customer as shown to operations staff.
+type CustomerDetailResponse struct {
+	Customer      *models.Customer      `json:"customer"`
+	Subscriptions []models.Subscription `json:"subscriptions"`
+	Entitlements  []CurrentEntitlement  `json:"entitlements"`
+}
+
+// UpdateProductRequest represents a change of product metadata. Omitted fields are left as they are.
+type UpdateProductRequest struct {
+	ProductID      *string `json:"product_id"`
+	ProductName    *string `json:"product_name"`
+	LogoURL        *string `json:"logo_url"`
+	SupportContact *string `json:"support_contact"`
+	RedirectURL    *string `json:"redirect_url"`
 }
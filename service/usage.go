@@ -0,0 +1,454 @@
+package service
+
+import (
+	"aws-markertplace-integration/db/models"
+	"aws-markertplace-integration/db/repo"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacemetering"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacemetering/types"
+	"github.com/aws/smithy-go"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// usageBatchSize is the maximum number of records BatchMeterUsage accepts per call
+	usageBatchSize = 25
+	// usageSubmitLimit is the maximum number of buckets submitted per run
+	usageSubmitLimit = 500
+	// maxUsageClockSkew is how far in the future a usage timestamp may be
+	maxUsageClockSkew = 5 * time.Minute
+	// defaultUsageSubmitInterval is the default interval between usage submission runs
+	defaultUsageSubmitInterval = time.Minute
+	// maxUsageAttempts is the number of attempts after which a bucket is dead-lettered
+	maxUsageAttempts = 8
+	// usageRetryBaseDelay is the delay before the first retry of a bucket
+	usageRetryBaseDelay = time.Minute
+	// usageRetryMaxDelay caps the delay between retries
+	usageRetryMaxDelay = 30 * time.Minute
+)
+
+// Ingestion outcomes reported for each usage record
+const (
+	usageAckAccepted       = "accepted"
+	usageAckCarriedForward = "carried_forward"
+	usageAckDuplicate      = "duplicate"
+	usageAckRejected       = "rejected"
+)
+
+// WithUsageSubmitInterval sets the interval between usage submission runs
+func WithUsageSubmitInterval(interval time.Duration) Option {
+	return func(s *Service) {
+		if interval > 0 {
+			s.usageSubmitInterval = interval
+		}
+	}
+}
+
+// WithLateUsagePolicy sets how usage for an already submitted hour is handled
+func WithLateUsagePolicy(policy repo.LateUsagePolicy) Option {
+	return func(s *Service) {
+		s.lateUsagePolicy = policy
+	}
+}
+
+// handleUsageIngestion stores usage records reported by product backends and aggregates
+// them into hourly buckets for later submission
+func (s *Service) handleUsageIngestion(c *gin.Context) {
+	var req UsageIngestionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		s.logger.Errorw("Invalid usage payload", "error", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request payload",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	now := time.Now()
+	records := make([]models.UsageRecord, 0, len(req.Records))
+	for i, r := range req.Records {
+		if r.Timestamp.After(now.Add(maxUsageClockSkew)) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request payload",
+				"details": fmt.Sprintf("records[%d]: timestamp is in the future", i),
+			})
+			return
+		}
+		record := models.UsageRecord{
+			CustomerIdentifier: r.CustomerIdentifier,
+			ProductCode:        r.ProductCode,
+			Dimension:          r.Dimension,
+			Quantity:           *r.Quantity,
+			UsageTimestamp:     r.Timestamp.UTC().Truncate(time.Second),
+		}
+		if r.IdempotencyKey != "" {
+			key := r.IdempotencyKey
+			record.IdempotencyKey = &key
+		}
+		allocations, err := usageAllocations(r)
+		if err != nil {
+			s.logger.Errorw("Invalid usage allocations",
+				"customerIdentifier", r.CustomerIdentifier,
+				"dimension", r.Dimension,
+				"error", err.Error())
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid usage allocations",
+				"details": fmt.Sprintf("records[%d]: %v", i, err),
+			})
+			return
+		}
+		record.Allocations = allocations
+		records = append(records, record)
+	}
+
+	stored, err := s.repo.CreateUsageRecords(c.Request.Context(), records, s.lateUsagePolicy)
+	if err != nil {
+		s.logger.Errorw("Failed to store usage records",
+			"count", len(records),
+			"error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store usage records"})
+		return
+	}
+
+	response := UsageIngestionResponse{Records: make([]UsageRecordAck, 0, len(stored))}
+	for _, st := range stored {
+		ack := UsageRecordAck{
+			UsageRecordID: st.Record.UsageRecordID,
+			Status:        usageAckAccepted,
+		}
+		if st.Record.UsageBucketID != nil {
+			ack.UsageBucketID = *st.Record.UsageBucketID
+		}
+		if st.Record.IdempotencyKey != nil {
+			ack.IdempotencyKey = *st.Record.IdempotencyKey
+		}
+		switch {
+		case st.Duplicate:
+			ack.Status = usageAckDuplicate
+		case st.Rejected:
+			ack.Status = usageAckRejected
+		case st.Record.CarriedForward:
+			ack.Status = usageAckCarriedForward
+		}
+		response.Records = append(response.Records, ack)
+	}
+
+	s.logger.Infow("Usage records ingested", "count", len(stored))
+	c.JSON(http.StatusAccepted, response)
+}
+
+// usageAllocations validates the allocations of a usage record and converts them to the model.
+// Allocation quantities must add up to the record quantity and tag sets must be distinct.
+func usageAllocations(r UsageRecordRequest) ([]models.UsageRecordAllocation, error) {
+	if len(r.Allocations) == 0 {
+		return nil, nil
+	}
+
+	var total int64
+	seen := make(map[string]int, len(r.Allocations))
+	allocations := make([]models.UsageRecordAllocation, 0, len(r.Allocations))
+	for i, a := range r.Allocations {
+		tags := make([]models.UsageTag, 0, len(a.Tags))
+		keys := make([]string, 0, len(a.Tags))
+		for _, tag := range a.Tags {
+			if slices.Contains(keys, tag.Key) {
+				return nil, fmt.Errorf("allocations[%d]: duplicate tag key %q", i, tag.Key)
+			}
+			keys = append(keys, tag.Key)
+			tags = append(tags, models.UsageTag{Key: tag.Key, Value: tag.Value})
+		}
+
+		sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+		tagSet := fmt.Sprint(tags)
+		if j, dup := seen[tagSet]; dup {
+			return nil, fmt.Errorf("allocations[%d]: same tags as allocations[%d]", i, j)
+		}
+		seen[tagSet] = i
+
+		total += *a.Quantity
+		allocations = append(allocations, models.UsageRecordAllocation{Quantity: *a.Quantity, Tags: tags})
+	}
+
+	if total != *r.Quantity {
+		return nil, fmt.Errorf("allocation quantities add up to %d but the record quantity is %d", total, *r.Quantity)
+	}
+	return allocations, nil
+}
+
+// submitClosedUsageHours submits the usage buckets of closed hours to AWS in batches per product
+func (s *Service) submitClosedUsageHours(ctx context.Context) {
+	currentHour := time.Now().UTC().Truncate(time.Hour)
+	buckets, err := s.repo.ClaimUsageBuckets(ctx, currentHour, usageSubmitLimit)
+	if err != nil {
+		s.logger.Errorw("Failed to claim usage buckets", "error", err.Error())
+		return
+	}
+	if len(buckets) == 0 {
+		return
+	}
+
+	byProduct := make(map[string][]models.UsageBucket)
+	for _, bucket := range buckets {
+		byProduct[bucket.ProductCode] = append(byProduct[bucket.ProductCode], bucket)
+	}
+
+	for productCode, productBuckets := range byProduct {
+		for start := 0; start < len(productBuckets); start += usageBatchSize {
+			end := min(start+usageBatchSize, len(productBuckets))
+			s.submitUsageBatch(ctx, productCode, productBuckets[start:end])
+		}
+	}
+}
+
+// submitUsageBatch calls BatchMeterUsage for a batch of buckets of a single product and
+// reconciles the per-record results with the buckets they belong to
+func (s *Service) submitUsageBatch(ctx context.Context, productCode string, batch []models.UsageBucket) {
+	input := &marketplacemetering.BatchMeterUsageInput{
+		ProductCode:  aws.String(productCode),
+		UsageRecords: make([]types.UsageRecord, 0, len(batch)),
+	}
+	pending := make(map[string]models.UsageBucket, len(batch))
+	var results []repo.UsageBucketResult
+	for _, bucket := range batch {
+		if bucket.Quantity > math.MaxInt32 {
+			results = append(results, repo.UsageBucketResult{
+				UsageBucketID: bucket.UsageBucketID,
+				Status:        models.UsageBucketStatusFailed,
+				Reason:        "QuantityOutOfRange",
+				Message:       "quantity exceeds the maximum accepted by AWS",
+			})
+			continue
+		}
+		timestamp := usageBucketTimestamp(bucket.UsageHour)
+		input.UsageRecords = append(input.UsageRecords, types.UsageRecord{
+			CustomerIdentifier: aws.String(bucket.CustomerIdentifier),
+			Dimension:          aws.String(bucket.Dimension),
+			Quantity:           aws.Int32(int32(bucket.Quantity)),
+			Timestamp:          aws.Time(timestamp),
+			UsageAllocations:   meteringUsageAllocations(bucket.Allocations),
+		})
+		pending[usageRecordKey(bucket.CustomerIdentifier, bucket.Dimension, timestamp)] = bucket
+	}
+
+	var retries []repo.UsageBucketRetry
+	if len(input.UsageRecords) > 0 {
+		out, err := s.meteringClient(productCode).BatchMeterUsage(ctx, input)
+		switch {
+		case err != nil && !isRetryableMeteringError(err) && len(input.UsageRecords) > 1:
+			// A single invalid record fails the whole call, so submit the records one by one
+			// to find out which of them AWS rejects
+			s.logger.Warnw("BatchMeterUsage rejected batch, submitting records individually",
+				"productCode", productCode,
+				"count", len(input.UsageRecords),
+				"error", err.Error())
+			for _, bucket := range pending {
+				s.submitUsageBatch(ctx, productCode, []models.UsageBucket{bucket})
+			}
+			pending = nil
+		case err != nil:
+			s.logger.Errorw("BatchMeterUsage failed",
+				"productCode", productCode,
+				"count", len(input.UsageRecords),
+				"error", err.Error())
+			for key, bucket := range pending {
+				if isRetryableMeteringError(err) {
+					retries, results = s.scheduleUsageRetry(retries, results, bucket, err.Error())
+				} else {
+					results = append(results, repo.UsageBucketResult{
+						UsageBucketID: bucket.UsageBucketID,
+						Status:        models.UsageBucketStatusFailed,
+						Reason:        meteringErrorCode(err),
+						Message:       err.Error(),
+					})
+				}
+				delete(pending, key)
+			}
+		default:
+			for _, res := range out.Results {
+				if res.UsageRecord == nil {
+					continue
+				}
+				key := usageRecordKey(aws.ToString(res.UsageRecord.CustomerIdentifier), aws.ToString(res.UsageRecord.Dimension), aws.ToTime(res.UsageRecord.Timestamp))
+				bucket, ok := pending[key]
+				if !ok {
+					s.logger.Warnw("Unmatched metering result",
+						"productCode", productCode,
+						"customerIdentifier", aws.ToString(res.UsageRecord.CustomerIdentifier),
+						"dimension", aws.ToString(res.UsageRecord.Dimension))
+					continue
+				}
+				delete(pending, key)
+				results = append(results, usageBucketResult(bucket, res))
+			}
+			s.logger.Infow("Usage batch submitted",
+				"productCode", productCode,
+				"count", len(input.UsageRecords),
+				"results", len(out.Results),
+				"unprocessed", len(out.UnprocessedRecords))
+		}
+	}
+
+	// Unprocessed records and records without a result are retried with backoff
+	for _, bucket := range pending {
+		retries, results = s.scheduleUsageRetry(retries, results, bucket, "unprocessed")
+	}
+
+	if err := s.repo.RetryUsageBuckets(ctx, retries); err != nil {
+		s.logger.Errorw("Failed to schedule usage retries",
+			"productCode", productCode,
+			"count", len(retries),
+			"error", err.Error())
+	}
+	if err := s.repo.UpdateUsageBucketResults(ctx, results); err != nil {
+		s.logger.Errorw("Failed to store metering results",
+			"productCode", productCode,
+			"error", err.Error())
+	}
+	for _, result := range results {
+		if result.Status == models.UsageBucketStatusFailed {
+			s.logger.Warnw("Usage bucket moved to dead-letter table",
+				"usageBucketId", result.UsageBucketID,
+				"productCode", productCode,
+				"reason", result.Reason)
+		}
+	}
+}
+
+// meteringUsageAllocations converts the allocations of a bucket to the metering format. Buckets
+// whose usage is not tagged at all are submitted without allocations.
+func meteringUsageAllocations(allocations []models.UsageAllocation) []types.UsageAllocation {
+	if len(allocations) == 0 || (len(allocations) == 1 && len(allocations[0].Tags) == 0) {
+		return nil
+	}
+
+	result := make([]types.UsageAllocation, 0, len(allocations))
+	for _, allocation := range allocations {
+		if allocation.Quantity == 0 {
+			continue
+		}
+		usageAllocation := types.UsageAllocation{
+			AllocatedUsageQuantity: aws.Int32(int32(allocation.Quantity)),
+		}
+		for _, tag := range allocation.Tags {
+			usageAllocation.Tags = append(usageAllocation.Tags, types.Tag{
+				Key:   aws.String(tag.Key),
+				Value: aws.String(tag.Value),
+			})
+		}
+		result = append(result, usageAllocation)
+	}
+	return result
+}
+
+// usageBucketResult maps a per-record BatchMeterUsage result to the final bucket outcome
+func usageBucketResult(bucket models.UsageBucket, res types.UsageRecordResult) repo.UsageBucketResult {
+	result := repo.UsageBucketResult{
+		UsageBucketID:    bucket.UsageBucketID,
+		MeteringStatus:   string(res.Status),
+		MeteringRecordID: res.MeteringRecordId,
+	}
+	switch res.Status {
+	case types.UsageRecordResultStatusSuccess:
+		result.Status = models.UsageBucketStatusSubmitted
+	default:
+		result.Status = models.UsageBucketStatusFailed
+		result.Reason = string(res.Status)
+		result.Message = fmt.Sprintf("record rejected by AWS with status %s", res.Status)
+	}
+	return result
+}
+
+// scheduleUsageRetry schedules the next attempt of a bucket with exponential backoff, or moves
+// it to the dead-letter table once it has used up its attempts
+func (s *Service) scheduleUsageRetry(retries []repo.UsageBucketRetry, results []repo.UsageBucketResult, bucket models.UsageBucket, message string) ([]repo.UsageBucketRetry, []repo.UsageBucketResult) {
+	if bucket.Attempts+1 >= maxUsageAttempts {
+		return retries, append(results, repo.UsageBucketResult{
+			UsageBucketID: bucket.UsageBucketID,
+			Status:        models.UsageBucketStatusFailed,
+			Reason:        "MaxAttemptsExceeded",
+			Message:       message,
+		})
+	}
+	return append(retries, repo.UsageBucketRetry{
+		UsageBucketID: bucket.UsageBucketID,
+		NextAttemptAt: time.Now().Add(usageRetryBackoff(bucket.Attempts)),
+		Message:       message,
+	}), results
+}
+
+// usageRetryBackoff returns the delay before the next attempt, doubling with every attempt
+func usageRetryBackoff(attempts int) time.Duration {
+	backoff := usageRetryBaseDelay << min(attempts, 10)
+	if backoff > usageRetryMaxDelay {
+		backoff = usageRetryMaxDelay
+	}
+	// Add up to 20% jitter so that throttled batches do not retry in lockstep
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/5+1))
+}
+
+// isRetryableMeteringError reports whether a failed BatchMeterUsage call may succeed later
+func isRetryableMeteringError(err error) bool {
+	var ae smithy.APIError
+	if !errors.As(err, &ae) {
+		// Transport errors and cancellations are transient
+		return true
+	}
+	switch ae.ErrorCode() {
+	case "ThrottlingException", "InternalServiceErrorException":
+		return true
+	default:
+		return false
+	}
+}
+
+// meteringErrorCode returns the AWS error code of err, if any
+func meteringErrorCode(err error) string {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		return ae.ErrorCode()
+	}
+	return "UnknownError"
+}
+
+// handleUsageDeadLetters lists usage buckets that AWS rejected permanently
+func (s *Service) handleUsageDeadLetters(c *gin.Context) {
+	limit := 100
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		limit = n
+	}
+
+	deadLetters, err := s.repo.ListUsageDeadLetters(c.Request.Context(), limit)
+	if err != nil {
+		s.logger.Errorw("Failed to list usage dead letters", "error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list dead letters"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"dead_letters": deadLetters})
+}
+
+// usageBucketTimestamp returns the timestamp reported for a bucket. The last second of the
+// hour is used so that the record stays within the window AWS accepts for as long as possible.
+func usageBucketTimestamp(hour time.Time) time.Time {
+	return hour.Add(time.Hour - time.Second)
+}
+
+// usageRecordKey identifies a usage record within a BatchMeterUsage call
+func usageRecordKey(customerIdentifier, dimension string, timestamp time.Time) string {
+	return fmt.Sprintf("%s|%s|%d", customerIdentifier, dimension, timestamp.Unix())
+}
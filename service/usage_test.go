@@ -0,0 +1,230 @@
+package service
+
+import (
+	"aws-markertplace-integration/db/models"
+	"aws-markertplace-integration/db/repo"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacemetering"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacemetering/types"
+	"github.com/aws/smithy-go"
+	"go.uber.org/zap"
+)
+
+// fakeMeteringClient answers BatchMeterUsage calls with respond
+type fakeMeteringClient struct {
+	respond func(records []types.UsageRecord) (*marketplacemetering.BatchMeterUsageOutput, error)
+	calls   int
+}
+
+func (f *fakeMeteringClient) BatchMeterUsage(ctx context.Context, params *marketplacemetering.BatchMeterUsageInput, optFns ...func(*marketplacemetering.Options)) (*marketplacemetering.BatchMeterUsageOutput, error) {
+	f.calls++
+	return f.respond(params.UsageRecords)
+}
+
+func (f *fakeMeteringClient) ResolveCustomer(ctx context.Context, params *marketplacemetering.ResolveCustomerInput, optFns ...func(*marketplacemetering.Options)) (*marketplacemetering.ResolveCustomerOutput, error) {
+	return nil, errors.New("not implemented")
+}
+
+// fakeUsageRepository records the outcomes the usage submission stores
+type fakeUsageRepository struct {
+	repo.Repository
+	retries []repo.UsageBucketRetry
+	results []repo.UsageBucketResult
+}
+
+func (f *fakeUsageRepository) RetryUsageBuckets(ctx context.Context, retries []repo.UsageBucketRetry) error {
+	f.retries = append(f.retries, retries...)
+	return nil
+}
+
+func (f *fakeUsageRepository) UpdateUsageBucketResults(ctx context.Context, results []repo.UsageBucketResult) error {
+	f.results = append(f.results, results...)
+	return nil
+}
+
+// outcomes maps bucket ids to "retry", "submitted" or "failed: <reason>"
+func (f *fakeUsageRepository) outcomes() map[int64]string {
+	outcomes := make(map[int64]string)
+	for _, retry := range f.retries {
+		outcomes[retry.UsageBucketID] = "retry"
+	}
+	for _, result := range f.results {
+		if result.Status == models.UsageBucketStatusFailed {
+			outcomes[result.UsageBucketID] = "failed: " + result.Reason
+		} else {
+			outcomes[result.UsageBucketID] = string(result.Status)
+		}
+	}
+	return outcomes
+}
+
+// meteringResults answers every record with the status listed for its customer. Records of
+// customers without a status are returned as unprocessed.
+func meteringResults(statuses map[string]types.UsageRecordResultStatus) func([]types.UsageRecord) (*marketplacemetering.BatchMeterUsageOutput, error) {
+	return func(records []types.UsageRecord) (*marketplacemetering.BatchMeterUsageOutput, error) {
+		out := &marketplacemetering.BatchMeterUsageOutput{}
+		for _, record := range records {
+			status, ok := statuses[aws.ToString(record.CustomerIdentifier)]
+			if !ok {
+				out.UnprocessedRecords = append(out.UnprocessedRecords, record)
+				continue
+			}
+			out.Results = append(out.Results, types.UsageRecordResult{
+				UsageRecord:      &record,
+				Status:           status,
+				MeteringRecordId: aws.String("record-" + aws.ToString(record.CustomerIdentifier)),
+			})
+		}
+		return out, nil
+	}
+}
+
+func TestSubmitUsageBatch(t *testing.T) {
+	hour := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+	bucket := func(id int64, customer string, quantity int64, attempts int) models.UsageBucket {
+		return models.UsageBucket{
+			UsageBucketID:      id,
+			ProductCode:        "p1",
+			CustomerIdentifier: customer,
+			Dimension:          "requests",
+			UsageHour:          hour,
+			Quantity:           quantity,
+			Attempts:           attempts,
+		}
+	}
+	invalidDimension := &smithy.GenericAPIError{Code: "InvalidUsageDimensionException", Message: "invalid dimension"}
+
+	tests := []struct {
+		name    string
+		batch   []models.UsageBucket
+		respond func([]types.UsageRecord) (*marketplacemetering.BatchMeterUsageOutput, error)
+		want    map[int64]string
+	}{
+		{
+			name:  "accepted and duplicate records",
+			batch: []models.UsageBucket{bucket(1, "c1", 3, 0), bucket(2, "c2", 4, 0)},
+			respond: meteringResults(map[string]types.UsageRecordResultStatus{
+				"c1": types.UsageRecordResultStatusSuccess,
+				"c2": types.UsageRecordResultStatusDuplicateRecord,
+			}),
+			want: map[int64]string{1: "submitted", 2: "failed: DuplicateRecord"},
+		},
+		{
+			name:  "rejected record is dead-lettered",
+			batch: []models.UsageBucket{bucket(1, "c1", 3, 0), bucket(2, "c2", 4, 0)},
+			respond: meteringResults(map[string]types.UsageRecordResultStatus{
+				"c1": types.UsageRecordResultStatusCustomerNotSubscribed,
+				"c2": types.UsageRecordResultStatusSuccess,
+			}),
+			want: map[int64]string{1: "failed: CustomerNotSubscribed", 2: "submitted"},
+		},
+		{
+			name:  "unprocessed record is retried",
+			batch: []models.UsageBucket{bucket(1, "c1", 3, 0), bucket(2, "c2", 4, 2)},
+			respond: meteringResults(map[string]types.UsageRecordResultStatus{
+				"c1": types.UsageRecordResultStatusSuccess,
+			}),
+			want: map[int64]string{1: "submitted", 2: "retry"},
+		},
+		{
+			name:    "unprocessed record out of attempts is dead-lettered",
+			batch:   []models.UsageBucket{bucket(1, "c1", 3, maxUsageAttempts-1)},
+			respond: meteringResults(nil),
+			want:    map[int64]string{1: "failed: MaxAttemptsExceeded"},
+		},
+		{
+			name:  "throttled batch is retried",
+			batch: []models.UsageBucket{bucket(1, "c1", 3, 0), bucket(2, "c2", 4, 0)},
+			respond: func([]types.UsageRecord) (*marketplacemetering.BatchMeterUsageOutput, error) {
+				return nil, &smithy.GenericAPIError{Code: "ThrottlingException", Message: "rate exceeded"}
+			},
+			want: map[int64]string{1: "retry", 2: "retry"},
+		},
+		{
+			name:  "rejected batch is submitted record by record",
+			batch: []models.UsageBucket{bucket(1, "c1", 3, 0), bucket(2, "c2", 4, 0)},
+			respond: func(records []types.UsageRecord) (*marketplacemetering.BatchMeterUsageOutput, error) {
+				for _, record := range records {
+					if aws.ToString(record.CustomerIdentifier) == "c2" {
+						return nil, invalidDimension
+					}
+				}
+				return meteringResults(map[string]types.UsageRecordResultStatus{"c1": types.UsageRecordResultStatusSuccess})(records)
+			},
+			want: map[int64]string{1: "submitted", 2: "failed: InvalidUsageDimensionException"},
+		},
+		{
+			name:    "quantity out of range is dead-lettered without a call",
+			batch:   []models.UsageBucket{bucket(1, "c1", 1<<32, 0)},
+			respond: meteringResults(nil),
+			want:    map[int64]string{1: "failed: QuantityOutOfRange"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := &fakeUsageRepository{}
+			s := New(aws.Config{}, 0, *zap.NewNop().Sugar(), repository)
+			s.MeteringClient = &fakeMeteringClient{respond: tt.respond}
+
+			s.submitUsageBatch(context.Background(), "p1", tt.batch)
+
+			got := repository.outcomes()
+			if len(got) != len(tt.want) {
+				t.Fatalf("outcomes = %v, want %v", got, tt.want)
+			}
+			for id, want := range tt.want {
+				if got[id] != want {
+					t.Errorf("bucket %d: outcome = %q, want %q", id, got[id], want)
+				}
+			}
+		})
+	}
+}
+
+func TestUsageRetryBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: usageRetryBaseDelay},
+		{attempts: 1, want: 2 * usageRetryBaseDelay},
+		{attempts: 3, want: 8 * usageRetryBaseDelay},
+		{attempts: 5, want: usageRetryMaxDelay},
+		{attempts: 40, want: usageRetryMaxDelay},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			got := usageRetryBackoff(tt.attempts)
+			if got < tt.want || got > tt.want+tt.want/5 {
+				t.Fatalf("usageRetryBackoff(%d) = %v, want %v plus at most 20%% jitter", tt.attempts, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestIsRetryableMeteringError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "throttling", err: &smithy.GenericAPIError{Code: "ThrottlingException"}, want: true},
+		{name: "internal service error", err: &smithy.GenericAPIError{Code: "InternalServiceErrorException"}, want: true},
+		{name: "invalid product code", err: &smithy.GenericAPIError{Code: "InvalidProductCodeException"}, want: false},
+		{name: "timestamp out of bounds", err: &smithy.GenericAPIError{Code: "TimestampOutOfBoundsException"}, want: false},
+		{name: "transport error", err: errors.New("connection reset by peer"), want: true},
+		{name: "cancellation", err: context.Canceled, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableMeteringError(tt.err); got != tt.want {
+				t.Fatalf("isRetryableMeteringError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"aws-markertplace-integration/db/models"
+	"aws-markertplace-integration/db/repo"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// webhookDispatchInterval is the interval between webhook dispatch runs
+	webhookDispatchInterval = 5 * time.Second
+	// webhookDispatchLimit bounds the number of deliveries attempted per run
+	webhookDispatchLimit = 100
+	// maxWebhookAttempts is the number of attempts after which a delivery is given up
+	maxWebhookAttempts = 10
+	// webhookRetryBaseDelay and webhookRetryMaxDelay bound the exponential retry backoff
+	webhookRetryBaseDelay = 30 * time.Second
+	webhookRetryMaxDelay  = time.Hour
+	// maxWebhookResponseSize bounds how much of a webhook response is read
+	maxWebhookResponseSize = 4 * 1024
+
+	// Headers of outbound webhook requests
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+	webhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// WebhookEndpoint is a downstream URL that receives events as signed JSON payloads
+type WebhookEndpoint struct {
+	URL    string
+	Secret string
+	// Events lists the event types sent to the endpoint; empty means every event
+	Events []string
+}
+
+// subscribes reports whether the endpoint receives events of the given type
+func (e WebhookEndpoint) subscribes(eventType string) bool {
+	return len(e.Events) == 0 || slices.Contains(e.Events, eventType)
+}
+
+// WithWebhooks sends every published event to the given endpoints
+func WithWebhooks(endpoints ...WebhookEndpoint) Option {
+	return func(s *Service) {
+		s.webhooks = append(s.webhooks, endpoints...)
+	}
+}
+
+// queueWebhooks stores a delivery of the event for every endpoint subscribed to it
+func (s *Service) queueWebhooks(ctx context.Context, event Event) error {
+	if len(s.webhooks) == 0 {
+		return nil
+	}
+	if s.repo == nil {
+		s.logger.Warnw("No repository configured, webhook not delivered",
+			"type", event.Type,
+			"eventId", event.ID)
+		return nil
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	var deliveries []models.WebhookDelivery
+	for _, endpoint := range s.webhooks {
+		if !endpoint.subscribes(event.Type) {
+			continue
+		}
+		deliveries = append(deliveries, models.WebhookDelivery{
+			EventID:   event.ID,
+			EventType: event.Type,
+			URL:       endpoint.URL,
+			Payload:   string(payload),
+		})
+	}
+	if err := s.repo.CreateWebhookDeliveries(ctx, deliveries); err != nil {
+		return fmt.Errorf("failed to queue webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// dispatchWebhooks attempts the deliveries that are due
+func (s *Service) dispatchWebhooks(ctx context.Context) {
+	deliveries, err := s.repo.ClaimWebhookDeliveries(ctx, webhookDispatchLimit)
+	if err != nil {
+		s.logger.Errorw("Failed to claim webhook deliveries", "error", err.Error())
+		return
+	}
+
+	for _, delivery := range deliveries {
+		result := s.deliverWebhook(ctx, delivery)
+		// Use a fresh context so that attempts made during shutdown are recorded too
+		saveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := s.repo.UpdateWebhookDeliveryResult(saveCtx, result)
+		cancel()
+		if err != nil {
+			s.logger.Errorw("Failed to record webhook delivery result",
+				"deliveryId", delivery.DeliveryID,
+				"error", err.Error())
+		}
+	}
+}
+
+// deliverWebhook sends a single delivery and decides whether it is retried
+func (s *Service) deliverWebhook(ctx context.Context, delivery models.WebhookDelivery) repo.WebhookDeliveryResult {
+	result := repo.WebhookDeliveryResult{DeliveryID: delivery.DeliveryID}
+
+	index := slices.IndexFunc(s.webhooks, func(e WebhookEndpoint) bool { return e.URL == delivery.URL })
+	if index < 0 {
+		result.Status = models.WebhookDeliveryStatusFailed
+		result.Error = "endpoint is no longer configured"
+		return result
+	}
+	endpoint := s.webhooks[index]
+
+	statusCode, err := s.postWebhook(ctx, endpoint, delivery)
+	result.StatusCode = statusCode
+	if err == nil {
+		result.Status = models.WebhookDeliveryStatusDelivered
+		s.logger.Infow("Webhook delivered",
+			"deliveryId", delivery.DeliveryID,
+			"eventType", delivery.EventType,
+			"url", delivery.URL)
+		return result
+	}
+
+	result.Error = err.Error()
+	attempts := delivery.Attempts + 1
+	if attempts >= maxWebhookAttempts {
+		result.Status = models.WebhookDeliveryStatusFailed
+		s.logger.Errorw("Webhook delivery failed permanently",
+			"deliveryId", delivery.DeliveryID,
+			"eventType", delivery.EventType,
+			"url", delivery.URL,
+			"attempts", attempts,
+			"error", result.Error)
+		return result
+	}
+
+	next := time.Now().Add(webhookRetryBackoff(attempts))
+	result.Status = models.WebhookDeliveryStatusPending
+	result.NextAttemptAt = &next
+	s.logger.Warnw("Webhook delivery failed, will retry",
+		"deliveryId", delivery.DeliveryID,
+		"eventType", delivery.EventType,
+		"url", delivery.URL,
+		"attempts", attempts,
+		"nextAttemptAt", next,
+		"error", result.Error)
+	return result
+}
+
+// postWebhook posts the payload of a delivery, signed with the endpoint secret
+func (s *Service) postWebhook(ctx context.Context, endpoint WebhookEndpoint, delivery models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to build webhook request: %w", err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, delivery.EventType)
+	req.Header.Set(webhookDeliveryHeader, delivery.EventID)
+	req.Header.Set(webhookSignatureHeader, fmt.Sprintf("t=%d,v1=%s", timestamp, signWebhookPayload(endpoint.Secret, timestamp, delivery.Payload)))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxWebhookResponseSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook endpoint returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhookPayload computes the HMAC-SHA256 signature of a payload. The timestamp is part of
+// the signed content, so that receivers can reject replayed requests.
+func signWebhookPayload(secret string, timestamp int64, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryBackoff returns the delay before the next attempt of a delivery
+func webhookRetryBackoff(attempts int) time.Duration {
+	backoff := webhookRetryBaseDelay << min(attempts-1, 10)
+	if backoff > webhookRetryMaxDelay {
+		backoff = webhookRetryMaxDelay
+	}
+	// Add up to 20% jitter so that deliveries to a recovering endpoint are spread out
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/5+1))
+}
+
+// handleWebhookDeliveries lists the most recent webhook deliveries
+func (s *Service) handleWebhookDeliveries(c *gin.Context) {
+	limit := 100
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		limit = n
+	}
+
+	status := models.WebhookDeliveryStatus(c.Query("status"))
+	switch status {
+	case "", models.WebhookDeliveryStatusPending, models.WebhookDeliveryStatusSending,
+		models.WebhookDeliveryStatusDelivered, models.WebhookDeliveryStatusFailed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		return
+	}
+
+	deliveries, err := s.repo.ListWebhookDeliveries(c.Request.Context(), status, limit)
+	if err != nil {
+		s.logger.Errorw("Failed to list webhook deliveries", "error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list webhook deliveries"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}